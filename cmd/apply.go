@@ -15,59 +15,148 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	lib "github.com/cisco-sso/mh/mhlib"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var (
+	prune  bool
+	dryRun bool
+)
+
 // applyCmd represents the apply command
 var applyCmd = &cobra.Command{
-	Use:   "apply",
+	Use:   "apply [APP]...",
 	Short: "Apply apps",
 	Long: `Apply one or more mh apps. If you do not specify one or more
-apps, mh acts on all apps in your mh config.`,
+apps, mh acts on all apps in your mh config. Apps are applied after the apps
+they depend on. With --wait, apps are only done once their Deployments,
+StatefulSets, DaemonSets and Jobs are ready. With --atomic, apps failing to
+apply are rolled back to their previous revision.
+
+With --prune, releases this mh config applied for apps that were removed from
+it are purged after all apps were applied, unless they were protected. Pruning
+asks for confirmation unless --yes is given; --dry-run only lists them.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		logger := logrus.New().WithField("command", "apply")
-		if viper.GetBool("json") {
-			logger.Logger.Formatter = new(logrus.JSONFormatter)
+		logger := newLogger("apply")
+		if prune && (len(args) > 0 || len(selectorFlag) > 0) {
+			logger.Fatal("Pruning requires applying all apps, as the others would be pruned")
+		}
+		if dryRun && !prune {
+			logger.Fatal("--dry-run requires --prune")
 		}
 		mhConfigFile := unmarshalConfig(logger)
 
 		// Build additional configuration from environment and CLI
 		envCLIConfig := lib.MHConfig{
-			PrintRendered:  printRendered,
-			NoRecreatePods: noRecreatePods,
-			SETValues:      setValuesFlag,
+			PrintRendered:     printRendered,
+			PrintSecrets:      printSecrets,
+			NoRecreatePods:    noRecreatePods,
+			Parallelism:       parallelism,
+			RollbackOnFailure: atomic,
+			SETValues:         setValuesFlag,
+			Wait:              wait,
+		}
+		if waitTimeout > 0 {
+			envCLIConfig.WaitTimeout = waitTimeout.String()
 		}
 
 		// Merge configuration from file, environment and CLI into default
 		// configuration
-		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig)
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig, kubeCLIConfig())
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
 		}
 
-		// Ensure TargetContext is the current kubectl context
-		ensureCurrentContext(logger, *effectiveMHConfig)
-
 		// Get effective apps
-		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), args, *effectiveMHConfig)
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), withDeps, *effectiveMHConfig)
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective apps")
 		}
 
-		if err := apps.Apply(viper.ConfigFileUsed()); err != nil {
-			logger.Fatal("Failed running apply")
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		// Render and validate the configuration once for all apps
+		config := renderConfig(logger)
+		validateConfig(logger, config)
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		if dryRun {
+			prunable, results := apps.Prunable(config)
+			if err := prunable.PrintTable(os.Stdout); err != nil {
+				logger.WithField("error", err).Error("Failed to print releases to prune")
+			}
+			if results.Failed() {
+				printResults(logger, "prune", results)
+			}
+			return
+		}
+
+		results := apps.Apply(config, options)
+		if prune && !results.Failed() {
+			results = append(results, pruneReleases(logger, apps, config)...)
 		}
+		printResults(logger, "apply", results)
 	},
 }
 
+// pruneReleases purges the releases of apps removed from the mh config once
+// the user confirmed them.
+func pruneReleases(logger *logrus.Entry, apps *lib.Apps, config *lib.RenderedConfig) lib.Results {
+	prunable, results := apps.Prunable(config)
+	if results.Failed() {
+		return results
+	}
+
+	unprotected := 0
+	for _, release := range prunable {
+		if !release.Protected {
+			unprotected++
+		}
+	}
+	if unprotected == 0 {
+		logger.Info("No releases to prune")
+		return nil
+	}
+
+	if err := prunable.PrintTable(os.Stderr); err != nil {
+		logger.WithField("error", err).Error("Failed to print releases to prune")
+	}
+	if !confirm(fmt.Sprintf("Purge %d releases of removed apps?", unprotected)) {
+		logger.Warn("Not pruning releases")
+		return nil
+	}
+
+	return prunable.Prune()
+}
+
 func init() {
 	RootCmd.AddCommand(applyCmd)
 
+	applyCmd.Flags().BoolVar(&printSecrets, "print-secrets", false, "print decrypted secrets instead of masking them")
+	applyCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
 	applyCmd.Flags().BoolVarP(&printRendered, "printRendered", "p", false, "print rendered override values")
 	applyCmd.Flags().BoolVar(&noRecreatePods, "no-recreate-pods", false, "do not recreate pods")
+	applyCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to apply concurrently")
+	applyCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	applyCmd.Flags().BoolVar(&wait, "wait", false, "wait until the workloads of each app are ready")
+	applyCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 0, "time to wait for each app to become ready (default 5m)")
+	applyCmd.Flags().BoolVar(&atomic, "atomic", false, "roll apps back if applying them fails, or delete them if they were installed first")
+	applyCmd.Flags().BoolVar(&prune, "prune", false, "purge releases of apps removed from the mh config")
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "with --prune, only list the releases to prune")
+	applyCmd.Flags().BoolVarP(&yes, "yes", "y", false, "prune without asking for confirmation")
+	applyCmd.Flags().BoolVar(&withDeps, "with-deps", false, "also apply the apps the given apps depend on")
 	applyCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
 		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
 }
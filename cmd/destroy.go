@@ -15,50 +15,102 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	lib "github.com/cisco-sso/mh/mhlib"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var forceProtected bool
+
 // destroyCmd represents the destroy command
 var destroyCmd = &cobra.Command{
-	Use:   "destroy",
+	Use:   "destroy [APP]...",
 	Short: "Destroy apps",
 	Long: `Destroy one or more mh apps. If you do not specify one or more
-apps, mh acts on all apps in your mh config.`,
+apps, mh acts on all apps in your mh config. Apps are destroyed before the
+apps they depend on.
+
+Destroying more than one app, or all apps, lists them and asks for
+confirmation unless --yes is given. Protected apps are skipped unless
+--force-protected is given.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		logger := logrus.New().WithField("command", "destroy")
-		if viper.GetBool("json") {
-			logger.Logger.Formatter = new(logrus.JSONFormatter)
-		}
+		logger := newLogger("destroy")
 		mhConfigFile := unmarshalConfig(logger)
 
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			Parallelism: parallelism,
+		}
+
 		// Merge configuration from file, environment and CLI into default
 		// configuration
-		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH)
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig, kubeCLIConfig())
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
 		}
 
-		// Ensure TargetContext is the current kubectl context
-		ensureCurrentContext(logger, *effectiveMHConfig)
-
 		// Get effective apps
-		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), args, *effectiveMHConfig)
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), false, *effectiveMHConfig)
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective apps")
 		}
 
-		if err := apps.Destroy(); err != nil {
-			logger.Fatal("Failed running destroy")
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		if len(*apps) == 0 {
+			logger.Info("No apps to destroy")
+			return
+		}
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		destroyOptions := lib.DestroyOptions{
+			Purge:          viper.GetBool("purge"),
+			ForceProtected: forceProtected,
 		}
+
+		// Make sure destroying many apps is intended
+		filtered := len(args) > 0 || len(selectorFlag) > 0
+		if len(*apps) > 1 || !filtered {
+			fmt.Fprintf(os.Stderr, "Apps to destroy:\n")
+			for _, app := range *apps {
+				protected := ""
+				if app.Protected && !forceProtected {
+					protected = " (protected, skipped)"
+				} else if app.Protected {
+					protected = " (protected)"
+				}
+				fmt.Fprintf(os.Stderr, "  %s in %s%s\n", app.ID, app.TargetContext, protected)
+			}
+			question := fmt.Sprintf("Destroy %d apps, keeping their release history?", len(*apps))
+			if destroyOptions.Purge {
+				question = fmt.Sprintf("Destroy %d apps and purge their release history?", len(*apps))
+			}
+			if !confirm(question) {
+				logger.Fatal("Destroying apps was not confirmed")
+			}
+		}
+
+		printResults(logger, "destroy", apps.Destroy(destroyOptions, options))
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(destroyCmd)
 
-	destroyCmd.PersistentFlags().BoolP("purge", "p", false, "purge this app from Helm Tiller")
+	destroyCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	destroyCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to destroy concurrently")
+	destroyCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	destroyCmd.Flags().BoolVarP(&yes, "yes", "y", false, "destroy without asking for confirmation")
+	destroyCmd.Flags().BoolVar(&forceProtected, "force-protected", false, "also destroy protected apps")
+	destroyCmd.PersistentFlags().BoolP("purge", "p", false, "delete release history, freeing the release names")
 	viper.BindPFlags(destroyCmd.PersistentFlags())
 }
@@ -0,0 +1,105 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var noColor bool
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [APP]...",
+	Short: "Show changes apply would make",
+	Long: `Show what would change in the cluster when applying one or more mh apps,
+as a unified diff of the release values and of each Kubernetes resource. If
+you do not specify one or more apps, mh acts on all apps in your mh config.
+
+Exits with status 2 if there are differences, so it can gate CI.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("diff")
+		mhConfigFile := unmarshalConfig(logger)
+
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			Parallelism: parallelism,
+			SETValues:   setValuesFlag,
+		}
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig, kubeCLIConfig())
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get effective apps
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), withDeps, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		diffs, results := apps.Diff(renderConfig(logger), options)
+
+		var changed, unchanged []string
+		for _, diff := range diffs {
+			if diff.Changed() {
+				diff.Print(os.Stdout, !noColor)
+				changed = append(changed, diff.App+" in "+diff.Context)
+			} else {
+				unchanged = append(unchanged, diff.App+" in "+diff.Context)
+			}
+		}
+		if len(unchanged) > 0 {
+			fmt.Printf("No changes: %s\n", strings.Join(unchanged, ", "))
+		}
+
+		if results.Failed() {
+			printResults(logger, "diff", results)
+		}
+		if len(changed) > 0 {
+			logger.WithField("apps", changed).Warn("Apps differ from the cluster")
+			os.Exit(2)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	diffCmd.Flags().BoolVar(&noColor, "no-color", false, "do not color the diff")
+	diffCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to diff concurrently")
+	diffCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	diffCmd.Flags().BoolVar(&withDeps, "with-deps", false, "also diff the apps the given apps depend on")
+	diffCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
+		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
+}
@@ -0,0 +1,102 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// driftCmd represents the drift command
+var driftCmd = &cobra.Command{
+	Use:   "drift [APP]...",
+	Short: "Detect releases that diverged from the config",
+	Long: `Compare the rendered values and chart version of one or more mh apps with
+their deployed releases, e.g. to catch manual helm upgrades. If you do not
+specify one or more apps, mh acts on all apps in your mh config and also lists
+orphans: releases mh applied that are no longer in your mh config.
+
+Exits with status 2 if anything drifted, so it can run from cron jobs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("drift")
+		mhConfigFile := unmarshalConfig(logger)
+
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			Parallelism: parallelism,
+			SETValues:   setValuesFlag,
+		}
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig, kubeCLIConfig())
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get effective apps
+		selector := appSelector(args)
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), selector, false, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		// Releases of unselected apps are not orphans
+		orphans := len(selector.Patterns) == 0 && len(selector.Labels) == 0
+		drifts, results := apps.Drift(renderConfig(logger), options, orphans)
+
+		var out bytes.Buffer
+		if viper.GetBool("json") {
+			err = drifts.PrintJSON(&out)
+		} else {
+			err = drifts.PrintTable(&out)
+		}
+		os.Stdout.WriteString(redactor(logger).Redact(out.String()))
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to print drift")
+		}
+
+		if results.Failed() {
+			printResults(logger, "drift", results)
+		}
+		if drifts.Drifted() {
+			logger.Warn("Releases drifted from the config")
+			os.Exit(2)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(driftCmd)
+
+	driftCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	driftCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to check concurrently")
+	driftCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	driftCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
+		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
+}
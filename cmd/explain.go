@@ -0,0 +1,108 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain APP [KEY]",
+	Short: "Explain where values of an app come from",
+	Long: `Explain where the values an app is rendered with come from. For each value
+below KEY, print the final value and the sources that contributed to it by
+rising priority: config files with line numbers, self-render passes and --set
+flags.
+
+KEY is a dot-separated path relative to the app's key, or to the root of the
+mh config if it starts with a dot. Without KEY, all values of the app's key
+are explained. With --selector, APP and the selector must select exactly one
+app.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("explain")
+		mhConfigFile := unmarshalConfig(logger)
+
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			PrintSecrets: printSecrets,
+			SETValues:    setValuesFlag,
+		}
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get the effective app
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args[:1]), false, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+		if len(*apps) != 1 {
+			logger.WithField("apps", len(*apps)).Fatal("Explain needs exactly one app")
+		}
+		app := (*apps)[0]
+
+		var key string
+		if len(args) > 1 {
+			key = args[1]
+		}
+		config := renderConfig(logger)
+
+		// Render the app first to learn its values matching sensitiveKeys
+		if _, err := app.Render(config); err != nil {
+			logger.WithField("error", err).Warn("Failed to render app, sensitive values may not be masked")
+		}
+
+		explanations, err := app.Explain(config, key)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to explain app")
+		}
+
+		var out bytes.Buffer
+		if viper.GetBool("json") {
+			err = explanations.PrintJSON(&out)
+		} else {
+			err = explanations.Print(&out)
+		}
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to print explanation")
+		}
+		if app.PrintSecrets {
+			os.Stdout.Write(out.Bytes())
+		} else {
+			os.Stdout.WriteString(config.Redactor.Redact(out.String()))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(explainCmd)
+
+	explainCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	explainCmd.Flags().BoolVar(&printSecrets, "print-secrets", false, "print decrypted secrets instead of masking them")
+	explainCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
+		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
+}
@@ -15,33 +15,88 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/codeskyblue/go-sh"
 	"github.com/spf13/viper"
 
 	lib "github.com/cisco-sso/mh/mhlib"
 )
 
-// ensureCurrentContext compares the current kubectl context with the targent
-// context and exits if they do not match.
-func ensureCurrentContext(logger *logrus.Entry, config lib.MHConfig) {
-	// Fetch current context
-	out, err := sh.Command("kubectl", "config", "current-context").Output()
-	if err != nil {
-		logger.WithField("error", err).Fatal("Failed running `kubectl config current-context`.")
+// newLogger returns the logger of a command, logging JSON if enabled. It masks
+// secrets once the mh configuration is rendered.
+func newLogger(command string) *logrus.Entry {
+	logger := logrus.New()
+
+	var formatter logrus.Formatter = new(logrus.TextFormatter)
+	if viper.GetBool("json") {
+		formatter = new(logrus.JSONFormatter)
 	}
-	currentContext := strings.TrimSuffix(string(out), "\n")
+	logger.Formatter = &lib.RedactingFormatter{Formatter: formatter}
+
+	return logger.WithField("command", command)
+}
 
-	// Compare contexts
-	if config.TargetContext != currentContext {
+// redactor returns the Redactor of a logger created by newLogger.
+func redactor(logger *logrus.Entry) *lib.Redactor {
+	if formatter, ok := logger.Logger.Formatter.(*lib.RedactingFormatter); ok {
+		return formatter.Redactor
+	}
+
+	return nil
+}
+
+// ensureContext exits if mh can not operate on the target context, see
+// lib.CheckContext.
+func ensureContext(logger *logrus.Entry, config lib.MHConfig) {
+	if err := lib.CheckContext(config); err != nil {
 		logger.WithFields(logrus.Fields{
-			"currentContext": currentContext,
-			"targetContext":  config.TargetContext,
-		}).Fatal("`kubectl config current-context` does not match configured targetContext")
+			"contextMode":   config.ContextMode,
+			"targetContext": config.TargetContext,
+			"error":         err,
+		}).Fatal("Failed to ensure target context")
+	}
+}
+
+// fanOut returns a copy of the apps for each context they target, or only for
+// the context given by --kube-context, and exits if mh can not operate on any
+// of the contexts.
+func fanOut(logger *logrus.Entry, apps *lib.Apps) *lib.Apps {
+	fannedOut, err := apps.FanOut(viper.GetString("kube-context"))
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to fan out apps to their contexts")
+	}
+
+	ensured := map[string]bool{}
+	for _, app := range fannedOut {
+		key := fmt.Sprintf("%s/%s/%s", app.ContextMode, app.TargetContext, app.Kubeconfig)
+		if !ensured[key] {
+			ensureContext(logger, app.MHConfig)
+			ensured[key] = true
+		}
 	}
+
+	return &fannedOut
+}
+
+// kubeCLIConfig returns the MHConfig selecting the cluster from the
+// --kube-context and --kubeconfig flags. A context given on the command line
+// is passed to Helm and kubectl explicitly.
+func kubeCLIConfig() lib.MHConfig {
+	config := lib.MHConfig{
+		TargetContext: viper.GetString("kube-context"),
+		Kubeconfig:    viper.GetString("kubeconfig"),
+	}
+	if config.TargetContext != "" {
+		config.ContextMode = lib.ContextModeExplicit
+	}
+
+	return config
 }
 
 // unmarshalConfig creates a MHConfigFile struct from MH_CONFIG
@@ -56,8 +111,90 @@ func unmarshalConfig(logger *logrus.Entry) lib.MHConfigFile {
 		logger.Warn("Top-level configuration is deprecated, move to the 'mh' key")
 		mhConfigFile.MH.TargetContext = mhConfigFile.TargetContext
 	}
+	// Top-level targetContexts fan all apps out
+	if len(mhConfigFile.TargetContexts) > 0 {
+		mhConfigFile.MH.TargetContexts = mhConfigFile.TargetContexts
+	}
 
 	logger = logger.WithField("configFile", viper.ConfigFileUsed())
 
 	return mhConfigFile
 }
+
+// renderConfig self-renders the mh configuration file once for all apps.
+func renderConfig(logger *logrus.Entry) *lib.RenderedConfig {
+	config, err := lib.RenderConfig(viper.ConfigFileUsed())
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to render mh configuration file")
+	}
+
+	// Record where releases are applied from
+	config.Provenance = lib.NewReleaseProvenance(config, versionNumber)
+
+	// Mask the configuration's secrets in logs from now on
+	if formatter, ok := logger.Logger.Formatter.(*lib.RedactingFormatter); ok {
+		formatter.Redactor = config.Redactor
+	}
+
+	return config
+}
+
+// validateConfig validates the rendered mh configuration and exits listing
+// all errors if it is invalid.
+func validateConfig(logger *logrus.Entry, config *lib.RenderedConfig) {
+	errs, err := lib.ValidateConfig(config)
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to validate mh configuration file")
+	}
+
+	for _, err := range errs {
+		logger.WithFields(logrus.Fields{
+			"path":     err.Path,
+			"location": err.Location,
+		}).Error(err.Message)
+	}
+	if len(errs) > 0 {
+		logger.WithField("errors", len(errs)).Fatal("Invalid mh configuration file")
+	}
+}
+
+// printResults prints a summary of results to stdout, as JSON if JSON logging
+// is enabled, and exits with an error if any app failed. Secrets in errors are
+// masked.
+func printResults(logger *logrus.Entry, action string, results lib.Results) {
+	var out bytes.Buffer
+	var err error
+	if viper.GetBool("json") {
+		err = results.PrintJSON(&out)
+	} else {
+		err = results.PrintTable(&out)
+	}
+	os.Stdout.WriteString(redactor(logger).Redact(out.String()))
+	if err != nil {
+		logger.WithField("error", err).Error("Failed to print results")
+	}
+
+	if results.Failed() {
+		logger.WithField("error", results.Err()).Fatalf("Failed running %s", action)
+	}
+}
+
+// confirm asks the user to type "yes" to go ahead, unless --yes was given.
+func confirm(question string) bool {
+	if yes {
+		return true
+	}
+
+	fmt.Fprintf(os.Stderr, "%s Type \"yes\" to continue: ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// appSelector returns the selector of apps given as arguments and via the
+// selector flag.
+func appSelector(args []string) lib.Selector {
+	return lib.Selector{
+		Patterns: args,
+		Labels:   selectorFlag,
+	}
+}
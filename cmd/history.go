@@ -0,0 +1,78 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyMax int
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [APP]...",
+	Short: "Show the release history of apps",
+	Long: `Show the revisions of the Helm releases of one or more mh apps with their
+timestamps, chart versions and the git SHA of the mh config they were applied
+from, if recorded. APP is the name or alias of an app, not its release name.
+If you do not specify one or more apps, mh acts on all apps in your mh config.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("history")
+		mhConfigFile := unmarshalConfig(logger)
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, kubeCLIConfig())
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get effective apps
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), false, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		histories, results := apps.History(historyMax, lib.RunOptions{KeepGoing: true})
+		if viper.GetBool("json") {
+			err = histories.PrintJSON(os.Stdout)
+		} else {
+			err = histories.PrintTable(os.Stdout)
+		}
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to print history")
+		}
+
+		if results.Failed() {
+			printResults(logger, "history", results)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	historyCmd.Flags().IntVar(&historyMax, "max", 10, "maximum number of revisions to show, 0 for all")
+}
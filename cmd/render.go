@@ -0,0 +1,85 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var outputDir string
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render [APP]...",
+	Short: "Render apps",
+	Long: `Render the overrides of one or more mh apps to files named <app>.yaml
+in an output directory, without running Helm or kubectl. If you do not specify
+one or more apps, mh acts on all apps in your mh config.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("render")
+		mhConfigFile := unmarshalConfig(logger)
+
+		if outputDir == "" {
+			logger.Fatal("Flag --output-dir is required")
+		}
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			logger.WithField("error", err).Fatal("Failed to create output directory")
+		}
+
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			PrintSecrets: printSecrets,
+			Parallelism:  parallelism,
+			SETValues:    setValuesFlag,
+		}
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get effective apps
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), withDeps, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		printResults(logger, "render", apps.Render(renderConfig(logger), outputDir, options))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(renderCmd)
+
+	renderCmd.Flags().BoolVar(&printSecrets, "print-secrets", false, "print decrypted secrets instead of masking them")
+	renderCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	renderCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "directory to write rendered apps to")
+	renderCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to render concurrently")
+	renderCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	renderCmd.Flags().BoolVar(&withDeps, "with-deps", false, "also render the apps the given apps depend on")
+	renderCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
+		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
+}
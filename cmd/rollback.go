@@ -0,0 +1,76 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strconv"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback APP [REVISION]",
+	Short: "Roll an app back to a previous revision",
+	Long: `Roll an app's Helm release back to REVISION, as listed by mh history. Without
+REVISION, the app is rolled back to the revision deployed before the latest
+one. APP is the name or alias of the app, not its release name. With
+--selector, it must select exactly one app.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("rollback")
+		mhConfigFile := unmarshalConfig(logger)
+
+		var revision int
+		if len(args) > 1 {
+			var err error
+			if revision, err = strconv.Atoi(args[1]); err != nil || revision < 1 {
+				logger.WithField("revision", args[1]).Fatal("Revision must be a positive number")
+			}
+		}
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, kubeCLIConfig())
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get the effective app
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args[:1]), false, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+		if len(*apps) != 1 {
+			logger.WithField("apps", len(*apps)).Fatal("Rollback needs exactly one app")
+		}
+
+		// Fan the app out to the contexts it targets, ensuring mh can operate
+		// on each of them
+		apps = fanOut(logger, apps)
+
+		printResults(logger, "rollback", apps.Rollback(revision, lib.RunOptions{KeepGoing: keepGoing}))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	rollbackCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other contexts after a rollback failed")
+}
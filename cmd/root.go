@@ -15,11 +15,14 @@
 package cmd
 
 import (
+	"bytes"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	lib "github.com/cisco-sso/mh/mhlib"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -52,7 +55,16 @@ overrides.`,
 var (
 	setValuesFlag  []string
 	printRendered  bool
+	printSecrets   bool
 	noRecreatePods bool
+	withDeps       bool
+	parallelism    int
+	keepGoing      bool
+	selectorFlag   []string
+	wait           bool
+	waitTimeout    time.Duration
+	atomic         bool
+	yes            bool
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -73,6 +85,9 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&configFileFlag, "config", "c", "",
 		`config file (you can instead set MH_CONFIG)`)
 	RootCmd.PersistentFlags().BoolP("json", "j", false, "set logging to JSON format")
+	RootCmd.PersistentFlags().String("kube-context", "",
+		`kubectl context to pass to Helm and kubectl (overrides targetContext and contextMode)`)
+	RootCmd.PersistentFlags().String("kubeconfig", "", `kubeconfig file to pass to Helm and kubectl`)
 
 	// Beware that init() happens too early to read values from Viper...
 	// See: https://github.com/spf13/cobra/issues/511
@@ -106,8 +121,13 @@ func initConfig() {
 	viper.SetEnvPrefix("mh") // will be uppercased automatically
 	viper.AutomaticEnv()     // read in environment variables that match
 
-	// If a configFile is found, read it in.
-	err := viper.ReadInConfig()
+	// If a configFile is found, read it in, composed with its includes and
+	// overlays the same way apps are rendered.
+	data, err := lib.LoadConfigFile(configFile)
+	if err == nil {
+		viper.SetConfigType("yaml")
+		err = viper.ReadConfig(bytes.NewReader(data))
+	}
 	if viper.GetBool("json") {
 		log.SetFormatter(&log.JSONFormatter{})
 	}
@@ -16,56 +16,67 @@ package cmd
 
 import (
 	lib "github.com/cisco-sso/mh/mhlib"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 // simulateCmd represents the simulate command
 var simulateCmd = &cobra.Command{
-	Use:   "simulate",
+	Use:   "simulate [APP]...",
 	Short: "Simulate apps",
 	Long: `Simulate the apply of one or more mh apps. If you do not specify one or more
 apps, mh acts on all apps in your mh config.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		logger := logrus.New().WithField("command", "simulate")
-		if viper.GetBool("json") {
-			logger.Logger.Formatter = new(logrus.JSONFormatter)
-		}
+		logger := newLogger("simulate")
 		mhConfigFile := unmarshalConfig(logger)
 
 		// Build additional configuration from environment and CLI
 		envCLIConfig := lib.MHConfig{
 			PrintRendered: printRendered,
+			PrintSecrets:  printSecrets,
+			Parallelism:   parallelism,
 			SETValues:     setValuesFlag,
 		}
 
 		// Merge configuration from file, environment and CLI into default
 		// configuration
-		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig)
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig, kubeCLIConfig())
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
 		}
 
-		// Ensure TargetContext is the current kubectl context
-		ensureCurrentContext(logger, *effectiveMHConfig)
-
 		// Get effective apps
-		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), args, *effectiveMHConfig)
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), withDeps, *effectiveMHConfig)
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective apps")
 		}
 
-		if err := apps.Simulate(viper.ConfigFileUsed()); err != nil {
-			logger.Fatal("Failed running simulate")
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		// Render and validate the configuration once for all apps
+		config := renderConfig(logger)
+		validateConfig(logger, config)
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
 		}
+		printResults(logger, "simulate", apps.Simulate(config, options))
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(simulateCmd)
 
+	simulateCmd.Flags().BoolVar(&printSecrets, "print-secrets", false, "print decrypted secrets instead of masking them")
+	simulateCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
 	simulateCmd.Flags().BoolVarP(&printRendered, "printRendered", "p", false, "print rendered override values")
+	simulateCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to simulate concurrently")
+	simulateCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	simulateCmd.Flags().BoolVar(&withDeps, "with-deps", false, "also simulate the apps the given apps depend on")
 	simulateCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
 		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
 }
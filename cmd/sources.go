@@ -0,0 +1,77 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// sourcesCmd represents the sources command
+var sourcesCmd = &cobra.Command{
+	Use:   "sources [APP]...",
+	Short: "List app sources and their files",
+	Long: `List every app source in your mh config in the order they are used,
+together with the app files they resolve to. Files shadowed by a source of
+higher priority and files of apps defined by multiple sources of the same
+priority are marked. If you specify one or more apps, only their files are
+listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("sources")
+		mhConfigFile := unmarshalConfig(logger)
+
+		appSources, err := mhConfigFile.EffectiveAppSources(logger, viper.ConfigFileUsed())
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective app sources")
+		}
+
+		// Only list the files of selected apps
+		if selector := appSelector(args); !selector.Empty() {
+			effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH)
+			if err != nil {
+				logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+			}
+			apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), selector, false, *effectiveMHConfig)
+			if err != nil {
+				logger.WithField("error", err).Fatal("Failed to build effective apps")
+			}
+
+			var names []string
+			for _, app := range *apps {
+				names = append(names, app.Name)
+			}
+			appSources = appSources.WithApps(names)
+		}
+
+		if viper.GetBool("json") {
+			err = appSources.PrintJSON(os.Stdout)
+		} else {
+			err = appSources.PrintTable(os.Stdout)
+		}
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to print app sources")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sourcesCmd)
+
+	sourcesCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+}
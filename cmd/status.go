@@ -15,53 +15,79 @@
 package cmd
 
 import (
+	"os"
+
 	lib "github.com/cisco-sso/mh/mhlib"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var statusProvenance bool
+
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status [APP]...",
 	Short: "Get status of apps",
 	Long: `Get status one or more mh apps. If you do not specify one or more
-apps, mh acts on all apps in your mh config.`,
+apps, mh acts on all apps in your mh config. With --provenance, show where
+their deployed releases were applied from instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		logger := logrus.New().WithField("command", "status")
-		if viper.GetBool("json") {
-			logger.Logger.Formatter = new(logrus.JSONFormatter)
-		}
+		logger := newLogger("status")
 		mhConfigFile := unmarshalConfig(logger)
 
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			Parallelism: parallelism,
+		}
+
 		// Merge configuration from file, environment and CLI into default
 		// configuration
-		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH)
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig, kubeCLIConfig())
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
 		}
 
-		// Ensure TargetContext is the current kubectl context
-		ensureCurrentContext(logger, *effectiveMHConfig)
-
 		// Get effective apps
-		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), args, *effectiveMHConfig)
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), withDeps, *effectiveMHConfig)
 		if err != nil {
 			logger.WithField("error", err).Fatal("Failed to build effective apps")
 		}
 
-		for _, app := range *apps {
-			err := app.Status()
-			if err != nil {
-				logger.WithFields(logrus.Fields{
-					"app":   app.Name,
-					"error": err,
-				}).Fatal("Failed running status")
-			}
+		// Fan apps out to the contexts they target, ensuring mh can operate on
+		// each of them
+		apps = fanOut(logger, apps)
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		if !statusProvenance {
+			printResults(logger, "status", apps.Status(options))
+			return
+		}
+
+		provenances, results := apps.Provenance(options)
+		if viper.GetBool("json") {
+			err = provenances.PrintJSON(os.Stdout)
+		} else {
+			err = provenances.PrintTable(os.Stdout)
+		}
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to print provenance")
+		}
+		if results.Failed() {
+			printResults(logger, "provenance", results)
 		}
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	statusCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to get status of concurrently")
+	statusCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	statusCmd.Flags().BoolVar(&withDeps, "with-deps", false, "also get status of the apps the given apps depend on")
+	statusCmd.Flags().BoolVar(&statusProvenance, "provenance", false, "show the recorded provenance of releases")
 }
@@ -0,0 +1,101 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	lib "github.com/cisco-sso/mh/mhlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	printSchema    bool
+	validateValues bool
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [APP]...",
+	Short: "Validate the mh config",
+	Long: `Validate the mh config against the JSON Schema of mh configs, reporting
+unknown keys with their file and line. With --values, also render apps and
+validate their overrides against the values.schema.json of charts on disk. If
+you do not specify one or more apps, mh validates all apps in your mh config.
+
+apply and simulate run the same validation before calling Helm.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := newLogger("validate")
+
+		if printSchema {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(lib.ConfigSchema()); err != nil {
+				logger.WithField("error", err).Fatal("Failed to print schema")
+			}
+			return
+		}
+
+		mhConfigFile := unmarshalConfig(logger)
+
+		config := renderConfig(logger)
+		validateConfig(logger, config)
+		logger.Info("mh configuration file is valid")
+
+		if !validateValues {
+			return
+		}
+
+		// Build additional configuration from environment and CLI
+		envCLIConfig := lib.MHConfig{
+			Parallelism: parallelism,
+			SETValues:   setValuesFlag,
+		}
+
+		// Merge configuration from file, environment and CLI into default
+		// configuration
+		effectiveMHConfig, err := lib.MergeMHConfigs(lib.DefaultMHConfig, mhConfigFile.MH, envCLIConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective MH configuration")
+		}
+
+		// Get effective apps
+		apps, err := mhConfigFile.EffectiveApps(logger, viper.ConfigFileUsed(), appSelector(args), false, *effectiveMHConfig)
+		if err != nil {
+			logger.WithField("error", err).Fatal("Failed to build effective apps")
+		}
+
+		options := lib.RunOptions{
+			Parallelism: effectiveMHConfig.Parallelism,
+			KeepGoing:   keepGoing,
+		}
+		printResults(logger, "validate", apps.Validate(config, options))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().BoolVar(&printSchema, "schema", false, "print the JSON Schema of mh configs and exit")
+	validateCmd.Flags().BoolVar(&validateValues, "values", false, "also validate rendered overrides against chart values schemas")
+	validateCmd.Flags().StringSliceVarP(&selectorFlag, "selector", "l", nil,
+		`select apps by labels, including team and maintainer (e.g. -l tier=data,team!=sre)`)
+	validateCmd.Flags().IntVar(&parallelism, "parallel", 0, "number of apps to validate concurrently")
+	validateCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "continue with other apps after an app failed")
+	validateCmd.Flags().StringSliceVar(&setValuesFlag, "set", nil,
+		`set mh values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)`)
+}
@@ -27,13 +27,11 @@ import (
 	"github.com/imdario/mergo"
 	"github.com/sirupsen/logrus"
 
-	"github.com/codeskyblue/go-sh"
 	"github.com/ghodss/yaml"
 	"github.com/hairyhenderson/gomplate"
 	"github.com/hairyhenderson/gomplate/data"
 	"github.com/smallfish/simpleyaml"
 	"github.com/stoewer/go-strcase"
-	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/engine"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/strvals"
@@ -47,12 +45,33 @@ import (
 //
 // Maybe: Get rid of Alias in favor of ID
 type AppConfig struct {
-	Alias     string   `yaml:"alias"`
-	File      *AppFile `yaml:"file"`
-	Key       string   `yaml:"key"`
-	Name      string   `yaml:"name"`
-	Namespace string   `yaml:"namespace"`
-	MHConfig
+	Alias     string            `yaml:"alias"`
+	DependsOn []string          `yaml:"dependsOn"`
+	File      *AppFile          `yaml:"file"`
+	Key       string            `yaml:"key"`
+	Labels    map[string]string `yaml:"labels"`
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace"`
+	// NoRollback opts the app out of RollbackOnFailure, e.g. for stateful
+	// apps.
+	NoRollback bool `yaml:"noRollback"`
+	// Protected apps are only destroyed when forced, and their releases are
+	// not pruned once they are removed.
+	Protected bool `yaml:"protected"`
+	// Protect is rejected in favor of Protected, so apps setting it do not
+	// silently lose their protection.
+	Protect  *bool `yaml:"protect"`
+	MHConfig `mapstructure:",squash"`
+}
+
+// id returns the ID an App created from the AppConfig will have. Alias is
+// prioritized over Name.
+func (c *AppConfig) id() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+
+	return c.Name
 }
 
 // AppConfigs is an array of AppConfig as defined in a mh configuration file.
@@ -62,8 +81,10 @@ type AppConfigs []AppConfig
 // configuration overrides.
 type App struct {
 	AppConfig
-	ID  string
-	log *logrus.Entry
+	ID      string
+	Backend ReleaseBackend
+	Kube    KubeClient
+	log     *logrus.Entry
 }
 
 // NewApp returns an App based on a appConfig and global MHConfig defaults.
@@ -74,30 +95,56 @@ func NewApp(logger *logrus.Entry, appConfig AppConfig, mhConfig MHConfig) (*App,
 		return nil, fmt.Errorf("Empty name for app: %v", appConfig)
 	}
 
-	// Set configuration defaults if not overridden
+	// Set configuration defaults if not overridden. An app's own targetContext
+	// overrides global targetContexts.
+	ownContext := appConfig.TargetContext != "" && len(appConfig.TargetContexts) == 0
 	err := mergo.Merge(&appConfig.MHConfig, mhConfig)
 	if err != nil {
 		return nil, err
 	}
+	if ownContext {
+		appConfig.TargetContexts = nil
+	}
+	if len(appConfig.TargetContexts) > 0 {
+		appConfig.TargetContext = appConfig.TargetContexts[0]
+	}
 
 	// Set App ID, prioritize Alias over Name.
-	var id string
-	if appConfig.Alias != "" {
-		id = appConfig.Alias
-	} else {
-		id = appConfig.Name
-	}
+	id := appConfig.id()
 
 	// Set App Key to default of ".ID" if not defined
 	if appConfig.Key == "" {
 		appConfig.Key = fmt.Sprintf(".%s", strcase.LowerCamelCase(id))
 	}
 
-	return &App{
-		appConfig,
-		id,
-		logger.WithField("app", appConfig.Name),
-	}, nil
+	app := &App{
+		AppConfig: appConfig,
+		ID:        id,
+		log:       logger.WithField("app", appConfig.Name),
+	}
+	if err := app.connect(); err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// connect sets the ReleaseBackend matching the App's Helm version and the
+// KubeClient, both operating on the App's context.
+func (a *App) connect() error {
+	backend, err := NewReleaseBackend(a.log, a.MHConfig)
+	if err != nil {
+		return err
+	}
+	kube, err := NewKubeOptions(a.MHConfig)
+	if err != nil {
+		return err
+	}
+
+	a.Backend = backend
+	a.Kube = NewKubectlClient(kube)
+
+	return nil
 }
 
 // Build app's chart dependencies.
@@ -112,17 +159,10 @@ func (a *App) Build(chart string) error {
 			"requirementsFile": requirementsFile,
 		}).Info("Building chart dependencies for app.")
 
-		// Start a new shell session here to avoid running `cd`.
-		session := sh.NewSession()
-		session.SetDir(chart)
-
-		// Run `helm dependency build` on the chart.
-		out, err := session.Command("helm", "dependency", "update").Output()
+		_, err := a.Backend.DependencyUpdate(chart)
 		if err != nil {
-			return fmt.Errorf("Failed to build chart dependencies for app: %v", out)
+			return fmt.Errorf("Failed to build chart dependencies for app: %v", err)
 		}
-
-		session.ShowCMD = true
 	}
 
 	return nil
@@ -130,20 +170,19 @@ func (a *App) Build(chart string) error {
 
 func (a *App) Destroy(purge bool) (*[]interface{}, error) {
 	a.log.Info("Destroying app")
-	cmd := []interface{}{"delete", a.ID}
-	if purge {
-		cmd = append(cmd, "--purge")
-	}
-	err := sh.Command("helm", cmd...).Run()
+	cmd, err := a.Backend.Delete(a.ID, a.Namespace, purge)
 	if err != nil {
-		return &cmd, fmt.Errorf("Helm delete failed for app")
+		return cmd, fmt.Errorf("Helm delete failed for app")
+	}
+	if purge {
+		return nil, forgetProvenance(a.Kube, a.ID, a.Namespace)
 	}
 
 	return nil, nil
 }
 
 func (a *App) Status() error {
-	err := sh.Command("helm", "status", a.ID).Run()
+	_, err := a.Backend.Status(a.ID, a.Namespace)
 	if err != nil {
 		return fmt.Errorf("Helm status failed")
 	}
@@ -151,98 +190,188 @@ func (a *App) Status() error {
 	return nil
 }
 
-func (a *App) Apply(configFile string) (*[]interface{}, error) {
+// Apply upgrades the App's release and, if Wait is set, waits for its
+// workloads to become ready. If RollbackOnFailure is set and NoRollback is
+// not, a failed upgrade is rolled back and a RollbackError returned.
+func (a *App) Apply(config *RenderedConfig) (*[]interface{}, error) {
 	a.log.Info("Applying app")
-	return a.apply(configFile, false)
+
+	rollback := a.RollbackOnFailure && !a.NoRollback
+	var previous *ReleaseRevision
+	if rollback {
+		var err error
+		if previous, err = a.deployedRevision(); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd, err := a.apply(config, false)
+	upgraded := err == nil
+	if err == nil && a.Wait {
+		err = a.waitReady()
+	}
+
+	// Only failures after running `helm upgrade` return its command
+	if err != nil && cmd != nil && rollback {
+		return cmd, a.rollback(previous, err)
+	}
+
+	// Record where the release was applied from, even if it did not become
+	// ready
+	if upgraded && config.Provenance != nil && !a.NoProvenance {
+		if recordErr := a.recordProvenance(config.Provenance.forApp(a, config.Redactor)); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	}
+
+	return cmd, err
 }
 
-func (a *App) apply(configFile string, simulate bool) (*[]interface{}, error) {
-	chart, chartVersion, overrides, err := a.render(configFile)
+func (a *App) apply(config *RenderedConfig, simulate bool) (*[]interface{}, error) {
+	rendered, err := a.Render(config)
 	if err != nil {
 		return nil, err
 	}
 
 	if a.PrintRendered {
-		fmt.Print(string(*overrides))
+		fmt.Print(a.printable(config, rendered.Overrides))
 	}
 
-	// Prepare to do `helm upgrade`
-	cmd := []interface{}{"upgrade", a.ID, *chart}
+	if err := a.ValidateValues(rendered); err != nil {
+		return nil, err
+	}
 
-	// "specify the exact chart version to install. If this is not specified, the latest version is installed"
-	if chartVersion != nil {
-		cmd = append(cmd, "--version", *chartVersion)
+	// If key-value "chart" inside app YAML is determined to be a file path,
+	// build/update dependencies for it. If not a path, we needn't build
+	// for it.
+	if rendered.IsPath() {
+		err = a.Build(rendered.Chart)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if simulate {
-		// "enable verbose output"
-		cmd = append(cmd, "--debug")
+	// Run `helm upgrade`
+	return a.Backend.Upgrade(a.upgradeOptions(rendered, simulate))
+}
 
-		// "simulate an upgrade"
-		cmd = append(cmd, "--dry-run")
+// printable returns rendered overrides with secrets and sensitive values
+// masked, unless printing secrets is allowed.
+func (a *App) printable(config *RenderedConfig, overrides []byte) string {
+	if a.PrintSecrets {
+		return string(overrides)
 	}
 
-	// "force resource update through delete/recreate if needed"
-	cmd = append(cmd, "--force")
+	overrides, _ = maskSensitiveYAML(overrides, nil, a.SensitiveKeys)
+	return config.Redactor.Redact(string(config.Redactor.redactYAML(overrides)))
+}
 
-	// "if a release by this name doesn't already exist, run an install"
-	cmd = append(cmd, "--install")
+// upgradeOptions returns the options to upgrade the App's release to a
+// rendered state. Pods are only recreated with Helm 2, as Helm 3 can not.
+func (a *App) upgradeOptions(rendered *Rendered, simulate bool) UpgradeOptions {
+	return UpgradeOptions{
+		Release:      a.ID,
+		Chart:        rendered.Chart,
+		Version:      rendered.Version,
+		Namespace:    a.Namespace,
+		Values:       rendered.Overrides,
+		DryRun:       simulate,
+		Force:        true,
+		Install:      true,
+		RecreatePods: !a.NoRecreatePods && a.HelmVersion != 3,
+	}
+}
+
+// Diff compares the deployed values and manifest of the App's release with
+// the rendered values and the manifest a dry-run upgrade produces.
+func (a *App) Diff(config *RenderedConfig) (*AppDiff, *[]interface{}, error) {
+	rendered, err := a.Render(config)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if !a.NoRecreatePods {
-		// "performs pods restart for the resource if applicable"
-		cmd = append(cmd, "--recreate-pods")
+	if rendered.IsPath() {
+		err = a.Build(rendered.Chart)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	// "namespace to install the release into. (Defaults to helm default behaviour => kubeconfig checked out ns)"
-	if a.Namespace != "" {
-		cmd = append(cmd, "--namespace", a.Namespace)
+	// Fetch the deployed state, if the release exists
+	installed := true
+	deployedValues, cmd, err := a.Backend.GetValues(a.ID, a.Namespace, 0)
+	if err == ErrReleaseNotFound {
+		installed = false
+	} else if err != nil {
+		return nil, cmd, err
 	}
 
-	// Make `helm upgrade` read overrides from stdin
-	cmd = append(cmd, "--values", "-")
+	var deployedManifest string
+	if installed {
+		deployedManifest, cmd, err = a.Backend.GetManifest(a.ID, a.Namespace)
+		if err != nil {
+			return nil, cmd, err
+		}
+	}
 
-	// Run `helm upgrade
-	err = sh.Command("helm", cmd...).SetInput(string(*overrides)).Run()
+	renderedManifest, cmd, err := a.Backend.RenderManifest(a.upgradeOptions(rendered, true))
 	if err != nil {
-		return &cmd, err
+		return nil, cmd, err
+	}
+
+	// Deployed values may hold former sensitive values
+	var deployed interface{}
+	if err := yaml.Unmarshal(deployedValues, &deployed); err == nil {
+		config.Redactor.addSensitive(deployed, a.SensitiveKeys)
 	}
-	return &cmd, nil
+
+	renderedValues := rendered.Overrides
+	if !a.PrintSecrets {
+		deployedValues, renderedValues = maskSensitiveYAML(deployedValues, renderedValues, a.SensitiveKeys)
+		deployedValues, renderedValues = config.Redactor.redactYAML(deployedValues), config.Redactor.redactYAML(renderedValues)
+	}
+
+	diff := newAppDiff(a.ID, installed, deployedValues, renderedValues, deployedManifest, renderedManifest)
+	if !a.PrintSecrets {
+		for i := range diff.Resources {
+			diff.Resources[i].Diff = config.Redactor.Redact(diff.Resources[i].Diff)
+		}
+	}
+
+	return diff, cmd, nil
 }
 
-func (a *App) Simulate(configFile string) (*[]interface{}, error) {
+func (a *App) Simulate(config *RenderedConfig) (*[]interface{}, error) {
 	a.log.Info("Simulating app")
-	return a.apply(configFile, true)
+	return a.apply(config, true)
 }
 
-func (a *App) render(configFile string) (*string, *string, *[]byte, error) {
-	var chartVersion string
-
-	// read the mh main.yaml
-	data, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Failed to read configFile %v: %v", configFile, err)
-	}
+// Rendered is the result of rendering an App.
+type Rendered struct {
+	Chart     string
+	Version   string
+	Overrides []byte
+}
 
-	// Self-render the main.yaml with gomplate functions and datasources
-	//   This does not apply to the app.yaml files.
-	contents := string(data)
-	renderedContents, err := selfRender(contents)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Failed to selfRender configFile %v: %v", configFile, err)
-	}
+// IsPath returns true if the rendered chart is a path to a chart on disk
+// rather than a chart in a repository.
+func (r *Rendered) IsPath() bool {
+	re := regexp.MustCompile("(^(\\.).*)|(^/.*)")
+	return re.MatchString(r.Chart)
+}
 
-	config, err := chartutil.ReadValues([]byte(renderedContents))
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Failed to load values from configFile: %v", err)
-	}
+// Render renders the App's overrides from its app file and the rendered mh
+// configuration, without running Helm.
+func (a *App) Render(config *RenderedConfig) (*Rendered, error) {
+	values := config.Values()
 
 	appData, err := ioutil.ReadFile(*a.File.Path) // app.yaml
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Failed to load data from appFile: %v", err)
+		return nil, fmt.Errorf("Failed to load data from appFile: %v", err)
 	}
 
 	// creating a literal
-	data = []byte(
+	data := []byte(
 		"{{- $name := \"" + a.ID + "\" }}\n" + "{{- $app := " + a.Key + " }}\n",
 	)
 
@@ -262,46 +391,63 @@ func (a *App) render(configFile string) (*string, *string, *[]byte, error) {
 
 	// Add config via --set command
 	for _, value := range a.MHConfig.SETValues {
-		err := strvals.ParseInto(value, config)
+		err := strvals.ParseInto(value, values)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("Failed to parse values provided via --set : %v", err)
+			return nil, fmt.Errorf("Failed to parse values provided via --set : %v", err)
 		}
 	}
 
-	out, err := engine.New().Render(fakeChart, config)
+	// The secret function marks values as sensitive, so they are masked
+	// wherever mh prints them.
+	e := engine.New()
+	e.FuncMap["secret"] = func(value interface{}) interface{} {
+		config.Redactor.add(value)
+		return value
+	}
+
+	out, err := e.Render(fakeChart, values)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Helm rendering engine failed to render fakeChart: %v", err)
+		return nil, fmt.Errorf("Helm rendering engine failed to render fakeChart: %v", err)
 	}
 
 	overrides := []byte(out["fake/templates/main"])
 
+	// Mask values of sensitive keys
+	var overridesValues interface{}
+	if err := yaml.Unmarshal(overrides, &overridesValues); err == nil {
+		config.Redactor.addSensitive(overridesValues, a.SensitiveKeys)
+	}
+
 	yml, err := simpleyaml.NewYaml(overrides)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Failed to load newly rendered overrides YAML: %v", err)
+		return nil, fmt.Errorf("Failed to load newly rendered overrides YAML: %v", err)
 	}
 
 	chart, err := yml.Get("chart").String()
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Failed to lookup chart in overrides YAML: %v", err)
+		return nil, fmt.Errorf("Failed to lookup chart in overrides YAML: %v", err)
 	}
-	chartVersion, _ = yml.Get("version").String()
+	chartVersion, _ := yml.Get("version").String()
 
-	// If key-value "chart" inside app YAML is determined to be a file path,
-	// build/update dependencies for it. If not a path, we needn't build
-	// for it.
-	re := regexp.MustCompile("(^(\\.).*)|(^/.*)")
-	isPath := re.MatchString(chart)
-	if isPath {
-		err = a.Build(chart)
-		if err != nil {
-			return nil, nil, nil, err
-		}
+	return &Rendered{
+		Chart:     chart,
+		Version:   chartVersion,
+		Overrides: overrides,
+	}, nil
+}
+
+func selfRender(templateValuesStr string) (string, error) {
+	passes, err := selfRenderPasses(templateValuesStr)
+	if passes == nil {
+		return "", err
 	}
 
-	return &chart, &chartVersion, &overrides, nil
+	return passes[len(passes)-1], err
 }
 
-func selfRender(templateValuesStr string) (string, error) {
+// selfRenderPasses self-renders like selfRender and returns the input followed
+// by the result of each pass that changed it.
+func selfRenderPasses(templateValuesStr string) ([]string, error) {
 	/*
 		This function will accept an input string and run it through the
 		  templating engine as both the values dictionary as well as the
@@ -315,7 +461,7 @@ func selfRender(templateValuesStr string) (string, error) {
 	var gomp gomplateConfig
 	err := yaml.Unmarshal([]byte(templateValuesStr), &gomp)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	funcs := template.FuncMap{
@@ -325,7 +471,7 @@ func selfRender(templateValuesStr string) (string, error) {
 	if len(gomp.Gomplate.DataSources) > 0 {
 		d, err := data.NewData(gomp.Gomplate.DataSources, gomp.Gomplate.DataSourceHeaders)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		for k, v := range gomplate.Funcs(d) {
 			funcs[k] = v
@@ -336,6 +482,7 @@ func selfRender(templateValuesStr string) (string, error) {
 		Option("missingkey=error").
 		Funcs(funcs)
 
+	passes := []string{templateValuesStr}
 	lastRender := templateValuesStr
 	for i := 0; i < 10; i++ {
 
@@ -343,28 +490,29 @@ func selfRender(templateValuesStr string) (string, error) {
 		vals := map[string]interface{}{}
 		err := yaml.Unmarshal([]byte(lastRender), &vals)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		// Run the the file through the tempating engine as both values
 		//   file and template file
 		tmpl.Parse(string(lastRender))
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		out := new(bytes.Buffer)
 		err = tmpl.Execute(out, vals)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		newRender := out.String()
 		if lastRender == newRender {
-			return newRender, nil // self-templating succeeded
+			return passes, nil // self-templating succeeded
 		} else {
 			lastRender = newRender
+			passes = append(passes, newRender)
 		}
 	}
 
-	return lastRender, errors.New("Self-templating failed")
+	return passes, errors.New("Self-templating failed")
 }
@@ -17,8 +17,10 @@ package mhlib
 // AppFile is represents the way to retrieve a certain mh app. It may currently
 // only contain a Path to a file on disk.
 //
+// Files of git AppSources are paths into their checkout in the cache directory.
+//
 // Todo: Extend AppFile, AppSources and App.render() together with alternative
-// sources like git or s3.
+// sources like s3.
 type AppFile struct {
 	Path *string `yaml:"path"`
 }
@@ -0,0 +1,176 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// appGraph is the dependency graph of AppConfigs as defined by their
+// DependsOn. Nodes are indexes into configs.
+type appGraph struct {
+	configs AppConfigs
+	deps    [][]int
+}
+
+// newAppGraph builds an appGraph from AppConfigs. Dependencies reference apps
+// by name or alias. A name shared by several aliased apps references all of
+// them. Dependencies that reference no app are returned as "app -> dependency".
+func newAppGraph(configs AppConfigs) (*appGraph, []string) {
+	indexes := map[string][]int{}
+	for i, config := range configs {
+		indexes[config.Name] = append(indexes[config.Name], i)
+		if config.Alias != "" && config.Alias != config.Name {
+			indexes[config.Alias] = append(indexes[config.Alias], i)
+		}
+	}
+
+	var unknown []string
+	deps := make([][]int, len(configs))
+	for i, config := range configs {
+		for _, dep := range config.DependsOn {
+			if _, ok := indexes[dep]; !ok {
+				unknown = append(unknown, fmt.Sprintf("%s -> %s", config.id(), dep))
+			}
+			deps[i] = append(deps[i], indexes[dep]...)
+		}
+	}
+
+	return &appGraph{configs, deps}, unknown
+}
+
+// cycle returns the IDs of apps forming a dependency cycle, starting and
+// ending with the same app, or nil if there is none.
+func (g *appGraph) cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.configs))
+	var stack []int
+
+	var visit func(i int) []string
+	visit = func(i int) []string {
+		state[i] = visiting
+		stack = append(stack, i)
+
+		for _, dep := range g.deps[i] {
+			switch state[dep] {
+			case visiting:
+				// Found a back edge, the cycle is the stack from dep on
+				var cycle []string
+				for j := len(stack) - 1; j >= 0; j-- {
+					if stack[j] == dep {
+						for _, k := range stack[j:] {
+							cycle = append(cycle, g.configs[k].id())
+						}
+						break
+					}
+				}
+				return append(cycle, g.configs[dep].id())
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range g.configs {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
+
+// waves groups the apps into waves, each depending only on apps in previous
+// waves. Apps keep their configuration order within a wave.
+func (g *appGraph) waves() ([][]int, error) {
+	done := make([]bool, len(g.configs))
+	remaining := len(g.configs)
+
+	var waves [][]int
+	for remaining > 0 {
+		var wave []int
+		for i := range g.configs {
+			if done[i] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range g.deps[i] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, i)
+			}
+		}
+
+		if len(wave) == 0 {
+			return nil, cycleError(g.cycle())
+		}
+
+		for _, i := range wave {
+			done[i] = true
+		}
+		remaining -= len(wave)
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
+}
+
+// dependencies returns the given apps and all apps they transitively depend
+// on.
+func (g *appGraph) dependencies(selected map[int]bool) map[int]bool {
+	result := map[int]bool{}
+
+	var visit func(i int)
+	visit = func(i int) {
+		if result[i] {
+			return
+		}
+		result[i] = true
+		for _, dep := range g.deps[i] {
+			visit(dep)
+		}
+	}
+
+	for i := range selected {
+		if selected[i] {
+			visit(i)
+		}
+	}
+
+	return result
+}
+
+// cycleError returns a readable error for a dependency cycle.
+func cycleError(cycle []string) error {
+	return fmt.Errorf("Dependency cycle between apps: %s", strings.Join(cycle, " -> "))
+}
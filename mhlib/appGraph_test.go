@@ -0,0 +1,94 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAppGraphWaves(t *testing.T) {
+	graph, unknown := newAppGraph(AppConfigs{
+		{Name: "app", DependsOn: []string{"ingress", "postgres"}},
+		{Name: "ingress", DependsOn: []string{"cert-manager"}},
+		{Name: "cert-manager"},
+		{Name: "postgres", Alias: "db"},
+		{Name: "postgres", Alias: "db2"},
+	})
+	if unknown != nil {
+		t.Fatalf("Unknown dependencies: %v", unknown)
+	}
+
+	waves, err := graph.waves()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]int{{2, 3, 4}, {1}, {0}}
+	if !reflect.DeepEqual(waves, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", waves, expected)
+	}
+}
+
+func TestAppGraphCycle(t *testing.T) {
+	graph, _ := newAppGraph(AppConfigs{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"c"}},
+		{Name: "c", DependsOn: []string{"a"}},
+		{Name: "d"},
+	})
+
+	expected := "Dependency cycle between apps: a -> b -> c -> a"
+	if err := cycleError(graph.cycle()); err.Error() != expected {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", err, expected)
+	}
+	if _, err := graph.waves(); err == nil {
+		t.Fatal("Waves of a cyclic graph were built")
+	}
+}
+
+func TestEffectiveAppsWithDeps(t *testing.T) {
+	mhConfigFile := MHConfigFile{
+		Apps: AppConfigs{
+			{Name: "foo", DependsOn: []string{"baz"}},
+			{Name: "bar", Alias: "baz"},
+		},
+		AppSources: testMHConfigFile.AppSources,
+	}
+	apps, config, backend := newTestApps(t, mhConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	filtered, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), config.File, Selector{Patterns: []string{"foo"}}, false, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*filtered) != 1 {
+		t.Fatalf("Filter without dependencies returned %d apps", len(*filtered))
+	}
+
+	withDeps, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), config.File, Selector{Patterns: []string{"foo"}}, true, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*withDeps) != 2 || (*withDeps)[0].ID != "baz" {
+		t.Fatalf("Filter with dependencies returned %v", *withDeps)
+	}
+
+	// Apply in dependency order, destroy in reverse
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := apps.Destroy(DestroyOptions{}, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Upgrade baz", "Upgrade foo", "Delete foo", "Delete baz"}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", methods, expected)
+	}
+}
@@ -24,11 +24,19 @@ import (
 // AppSourceConfig is what can be defined in a mh configuration file and is used
 // create an AppSource struct. It defines where mh looks for app files.
 //
-// Todo: Define kinds of sources here.
+// Kind "path" makes Source a directory, "configPath" a directory relative to
+// the mh configuration file and "git" the URL of a git repository that is
+// checked out at Ref and looked into at SubPath.
+//
+// If multiple AppSources define the same app, the one with the highest
+// Priority is used. Ties are resolved by the order of AppSources.
 type AppSourceConfig struct {
-	Kind   string `yaml:"kind"`
-	Name   string `yaml:"name"`
-	Source string `yaml:"source"`
+	Kind     string `yaml:"kind"`
+	Name     string `yaml:"name"`
+	Priority int    `yaml:"priority"`
+	Ref      string `yaml:"ref"`
+	Source   string `yaml:"source"`
+	SubPath  string `yaml:"subPath"`
 }
 
 // AppSourceConfigs is an Array of AppSourceConfigs defined in a mh configuration file
@@ -64,6 +72,12 @@ func NewAppSource(config AppSourceConfig, configFile string) (*AppSource, error)
 		pattern = config.Source + "/*.y*ml"
 	} else if config.Kind == "configPath" {
 		pattern = path.Dir(configFile) + "/" + config.Source + "/*.y*ml"
+	} else if config.Kind == "git" {
+		dir, err := gitCheckout(config.Source, config.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check out AppSource %s: %v", config.Name, err)
+		}
+		pattern = filepath.Join(dir, config.SubPath) + "/*.y*ml"
 	}
 
 	// Get all files the glob pattern matches
@@ -0,0 +1,159 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// newTestGitRepo creates a bare git repository with a branch master holding
+// apps/foo.yaml and a tag v1 holding apps/bar.yaml and returns its path.
+func newTestGitRepo(t *testing.T, dir string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "catalog.git")
+	run := func(dir string, args ...string) {
+		args = append([]string{"-c", "user.name=mh", "-c", "user.email=mh@example.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name string) {
+		path := filepath.Join(work, "apps", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(testAppFile), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "init", "--quiet")
+	run(work, "checkout", "--quiet", "-b", "master")
+	write("bar.yaml")
+	run(work, "add", ".")
+	run(work, "commit", "--quiet", "-m", "Add bar")
+	run(work, "tag", "v1")
+	run(work, "rm", "--quiet", "apps/bar.yaml")
+	write("foo.yaml")
+	run(work, "add", ".")
+	run(work, "commit", "--quiet", "-m", "Replace bar with foo")
+	run(dir, "clone", "--quiet", "--bare", work, bare)
+
+	return bare
+}
+
+func TestNewAppSourceGit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bare := newTestGitRepo(t, dir)
+
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	defer os.Setenv("XDG_CACHE_HOME", cacheHome)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+
+	tests := []struct {
+		source, ref string
+		expected    string
+	}{
+		{bare, "", "foo"},
+		{bare, "master", "foo"},
+		// A second checkout of the same source fetches into the existing clone
+		{bare, "v1", "bar"},
+		{"file://" + bare, "v1", "bar"},
+	}
+	for _, test := range tests {
+		config := AppSourceConfig{Name: "catalog", Kind: "git", Source: test.source, Ref: test.ref, SubPath: "apps"}
+		appSource, err := NewAppSource(config, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(appSource.Files) != 1 {
+			t.Fatalf("%s@%s: unexpected files %v", test.source, test.ref, appSource.Files)
+		}
+		file, ok := appSource.Files[test.expected]
+		if !ok {
+			t.Fatalf("%s@%s: file %s not found in %v", test.source, test.ref, test.expected, appSource.Files)
+		}
+		if _, err := os.Stat(*file.Path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := AppSourceConfig{Name: "catalog", Kind: "git", Source: bare, Ref: "missing"}
+	if _, err := NewAppSource(config, ""); err == nil {
+		t.Fatal("Expected an error for an unknown ref")
+	}
+}
+
+func TestNewAppSourceGitRefs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	defer os.Setenv("XDG_CACHE_HOME", cacheHome)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+
+	// A repository whose apps/foo.yaml differs between tag v1 and master
+	work := filepath.Join(dir, "work")
+	if err := os.MkdirAll(filepath.Join(work, "apps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...interface{}) {
+		args = append([]interface{}{"-c", "user.name=mh", "-c", "user.email=mh@example.com"}, args...)
+		if _, err := gitOutput(work, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "--quiet")
+	run("checkout", "--quiet", "-b", "master")
+	for _, version := range []string{"v1", "v2"} {
+		if err := ioutil.WriteFile(filepath.Join(work, "apps", "foo.yaml"), []byte("version: "+version+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run("add", ".")
+		run("commit", "--quiet", "-m", "Release "+version)
+		run("tag", version)
+	}
+
+	// Both refs are checked out before either app source's files are read,
+	// as when apps are rendered.
+	var sources []*AppSource
+	for _, ref := range []string{"v1", "master"} {
+		source, err := NewAppSource(AppSourceConfig{Name: ref, Kind: "git", Source: work, Ref: ref, SubPath: "apps"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+	for i, expected := range []string{"version: v1\n", "version: v2\n"} {
+		content, err := ioutil.ReadFile(*sources[i].Files["foo"].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("App source %s reads %q, expected %q", sources[i].Name, content, expected)
+		}
+	}
+}
@@ -14,18 +14,192 @@
 
 package mhlib
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
 // AppSources is an Array of AppSources at runtime.
 type AppSources []AppSource
 
-// File calls AppSource.File on all AppSources and returns on the first match.
-//
-// Todo: Add priorities in case of multiple matches.
-func (as *AppSources) File(appConfig *AppConfig) bool {
-	for _, appSource := range *as {
-		if appSource.File(appConfig) {
-			return true
+// Resolve returns the AppSources defining an app file of the given name in the
+// order they are used: by descending priority, then in the order they are
+// configured. The first one satisfies the app and shadows the others.
+func (as AppSources) Resolve(name string) AppSources {
+	var matches AppSources
+	for _, i := range as.resolve(name) {
+		matches = append(matches, as[i])
+	}
+
+	return matches
+}
+
+// resolve returns the indexes of the AppSources Resolve returns.
+func (as AppSources) resolve(name string) []int {
+	var matches []int
+	for i, appSource := range as {
+		if _, ok := appSource.Files[name]; ok {
+			matches = append(matches, i)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return as[matches[i]].Priority > as[matches[j]].Priority
+	})
+
+	return matches
+}
+
+// Ambiguous returns true if AppSources resolved for an app do not determine
+// which one satisfies it, because the first ones have the same priority.
+func (as AppSources) Ambiguous() bool {
+	return len(as) > 1 && as[0].Priority == as[1].Priority
+}
+
+// order returns the indexes of all AppSources in the order they are used: by
+// descending priority, then in the order they are configured.
+func (as AppSources) order() []int {
+	order := make([]int, len(as))
+	for i := range as {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return as[order[i]].Priority > as[order[j]].Priority
+	})
+
+	return order
+}
+
+// WithApps returns the AppSources with only the app files of the named apps.
+func (as AppSources) WithApps(names []string) AppSources {
+	var filtered AppSources
+	for _, appSource := range as {
+		files := AppFiles{}
+		for _, name := range names {
+			if file, ok := appSource.Files[name]; ok {
+				files[name] = file
+			}
+		}
+		filtered = append(filtered, AppSource{appSource.AppSourceConfig, files})
+	}
+
+	return filtered
+}
+
+// Names returns the names of the AppSources.
+func (as AppSources) Names() []string {
+	var names []string
+	for _, appSource := range as {
+		names = append(names, appSource.Name)
+	}
+
+	return names
+}
+
+// appSourceFile is an app file of an AppSource and how it resolves across all
+// AppSources.
+type appSourceFile struct {
+	App        string `json:"app"`
+	Path       string `json:"path"`
+	Status     string `json:"status"`
+	ShadowedBy string `json:"shadowedBy,omitempty"`
+}
+
+// files returns the app files of the AppSource at index i, sorted by app
+// name. Their status is "used" if the AppSource satisfies the app, "ambiguous"
+// if it does only by list order and "shadowed" otherwise.
+func (as AppSources) files(i int) []appSourceFile {
+	var names []string
+	for name := range as[i].Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []appSourceFile
+	for _, name := range names {
+		file := appSourceFile{App: name, Path: *as[i].Files[name].Path, Status: "used"}
+
+		matches := as.resolve(name)
+		if matches[0] != i {
+			file.Status = "shadowed"
+			file.ShadowedBy = as[matches[0]].Name
+		} else if as.Resolve(name).Ambiguous() {
+			file.Status = "ambiguous"
+		}
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// PrintTable prints all AppSources with their app files as a human readable
+// table, in the order they are used.
+func (as AppSources) PrintTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tKIND\tPRIORITY\tAPP\tSTATUS\tPATH")
+	for _, i := range as.order() {
+		appSource := as[i]
+		files := as.files(i)
+		if len(files) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t\t\t\n", appSource.Name, appSource.Kind, appSource.Priority)
+		}
+		for _, file := range files {
+			status := file.Status
+			if file.ShadowedBy != "" {
+				status += " by " + file.ShadowedBy
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+				appSource.Name,
+				appSource.Kind,
+				appSource.Priority,
+				file.App,
+				status,
+				file.Path,
+			)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// PrintJSON prints all AppSources with their app files as a JSON document, in
+// the order they are used.
+func (as AppSources) PrintJSON(w io.Writer) error {
+	type jsonAppSource struct {
+		Kind     string          `json:"kind"`
+		Name     string          `json:"name"`
+		Priority int             `json:"priority"`
+		Ref      string          `json:"ref,omitempty"`
+		Source   string          `json:"source"`
+		SubPath  string          `json:"subPath,omitempty"`
+		Files    []appSourceFile `json:"files"`
+	}
+	doc := struct {
+		Sources []jsonAppSource `json:"sources"`
+	}{
+		Sources: []jsonAppSource{},
+	}
+
+	for _, i := range as.order() {
+		appSource := as[i]
+		files := as.files(i)
+		if files == nil {
+			files = []appSourceFile{}
 		}
+		doc.Sources = append(doc.Sources, jsonAppSource{
+			Kind:     appSource.Kind,
+			Name:     appSource.Name,
+			Priority: appSource.Priority,
+			Ref:      appSource.Ref,
+			Source:   appSource.Source,
+			SubPath:  appSource.SubPath,
+			Files:    files,
+		})
 	}
 
-	return false
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
 }
@@ -0,0 +1,157 @@
+package mhlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestAppSources returns AppSources with app files of the given names.
+func newTestAppSources(configs AppSourceConfigs, files map[string][]string) AppSources {
+	var appSources AppSources
+	for _, config := range configs {
+		appFiles := AppFiles{}
+		for _, name := range files[config.Name] {
+			path := config.Name + "/" + name + ".yaml"
+			appFiles[name] = AppFile{Path: &path}
+		}
+		appSources = append(appSources, AppSource{config, appFiles})
+	}
+
+	return appSources
+}
+
+func TestAppSourcesResolve(t *testing.T) {
+	appSources := newTestAppSources(AppSourceConfigs{
+		{Name: "local"},
+		{Name: "catalog", Priority: 10},
+		{Name: "fallback"},
+	}, map[string][]string{
+		"local":    {"postgres", "redis"},
+		"catalog":  {"postgres"},
+		"fallback": {"redis"},
+	})
+
+	tests := []struct {
+		app       string
+		expected  []string
+		ambiguous bool
+	}{
+		{"postgres", []string{"catalog", "local"}, false},
+		{"redis", []string{"local", "fallback"}, true},
+		{"missing", nil, false},
+	}
+	for _, test := range tests {
+		matches := appSources.Resolve(test.app)
+		if names := matches.Names(); !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("%s: Actual: %v Expected: %v", test.app, names, test.expected)
+		}
+		if matches.Ambiguous() != test.ambiguous {
+			t.Fatalf("%s: expected ambiguous to be %v", test.app, test.ambiguous)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := appSources.PrintTable(&out); err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		rows = append(rows, strings.Join(strings.Fields(line), " "))
+	}
+	expected := []string{
+		"catalog 10 postgres used catalog/postgres.yaml",
+		"local 0 postgres shadowed by catalog local/postgres.yaml",
+		"local 0 redis ambiguous local/redis.yaml",
+		"fallback 0 redis shadowed by local fallback/redis.yaml",
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("\nActual: %q\nExpected: %q\n", rows, expected)
+	}
+
+	// The JSON document uses the keys of the mh config
+	out.Reset()
+	if err := appSources.PrintJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"kind"`, `"name": "catalog"`, `"priority": 10`, `"files"`} {
+		if !strings.Contains(out.String(), key) {
+			t.Fatalf("JSON does not contain %s:\n%s", key, out.String())
+		}
+	}
+}
+
+func TestAppSourcesWithApps(t *testing.T) {
+	appSources := newTestAppSources(AppSourceConfigs{
+		{Name: "local"},
+		{Name: "catalog", Priority: 10},
+	}, map[string][]string{
+		"local":   {"postgres", "redis"},
+		"catalog": {"postgres"},
+	})
+
+	filtered := appSources.WithApps([]string{"redis"})
+	if len(filtered) != 2 || len(filtered[0].Files) != 1 || len(filtered[1].Files) != 0 {
+		t.Fatalf("Unexpected app sources: %+v", filtered)
+	}
+	if _, ok := filtered[0].Files["redis"]; !ok {
+		t.Fatalf("App file of selected app missing: %+v", filtered[0].Files)
+	}
+}
+
+func TestEffectiveAppsStrict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, source := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, source), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, source, "foo.yaml"), []byte(testAppFile), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	mhConfigFile := MHConfigFile{
+		Apps: AppConfigs{{Name: "foo"}},
+		AppSources: AppSourceConfigs{
+			{Name: "a", Kind: "configPath", Source: "a"},
+			{Name: "b", Kind: "configPath", Source: "b"},
+		},
+	}
+	configFile := filepath.Join(dir, "main.yaml")
+
+	apps, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), configFile, Selector{}, false, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := *(*apps)[0].File.Path; path != filepath.Join(dir, "a", "foo.yaml") {
+		t.Fatalf("Unexpected app file: %s", path)
+	}
+
+	mhConfigFile.Strict = true
+	if _, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), configFile, Selector{}, false, DefaultMHConfig); err == nil {
+		t.Fatal("Expected an error for an ambiguous app in strict mode")
+	}
+
+	mhConfigFile.AppSources[1].Priority = 1
+	apps, err = mhConfigFile.EffectiveApps(logger.WithField("command", "test"), configFile, Selector{}, false, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := *(*apps)[0].File.Path; path != filepath.Join(dir, "b", "foo.yaml") {
+		t.Fatalf("Unexpected app file: %s", path)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"testing"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestSelfRender(t *testing.T) {
@@ -66,3 +68,33 @@ values:
 		t.FailNow()
 	}
 }
+
+func TestNewAppHelmVersion(t *testing.T) {
+	logger := logrus.New().WithField("command", "test")
+
+	app, err := NewApp(logger, AppConfig{Name: "foo", MHConfig: MHConfig{HelmVersion: 3}}, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.Backend.(*HelmV3Backend); !ok {
+		t.Fatalf("App overriding helmVersion 3 uses %T", app.Backend)
+	}
+	if app.upgradeOptions(&Rendered{}, false).RecreatePods {
+		t.Fatal("App using Helm 3 recreates pods")
+	}
+
+	app, err = NewApp(logger, AppConfig{Name: "foo"}, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.Backend.(*HelmV2Backend); !ok {
+		t.Fatalf("App with default helmVersion uses %T", app.Backend)
+	}
+	if !app.upgradeOptions(&Rendered{}, false).RecreatePods {
+		t.Fatal("App using Helm 2 does not recreate pods")
+	}
+
+	if _, err := NewApp(logger, AppConfig{Name: "foo", MHConfig: MHConfig{HelmVersion: 4}}, DefaultMHConfig); err == nil {
+		t.Fatal("App with unsupported helmVersion was created")
+	}
+}
@@ -15,63 +15,526 @@
 package mhlib
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/sirupsen/logrus"
 )
 
 // Apps is an array of apps.
 type Apps []App
 
-// Apply runs Apply on each App
-func (a Apps) Apply(configFile string) error {
+// configs returns the AppConfigs of the Apps.
+func (a Apps) configs() AppConfigs {
+	configs := AppConfigs{}
 	for _, app := range a {
-		cmd, err := app.Apply(configFile)
+		configs = append(configs, app.AppConfig)
+	}
+
+	return configs
+}
+
+// Waves groups the Apps into waves, each depending only on Apps in previous
+// waves. Dependencies on apps that are not part of Apps are ignored.
+func (a Apps) Waves() ([]Apps, error) {
+	graph, _ := newAppGraph(a.configs())
+	indexWaves, err := graph.waves()
+	if err != nil {
+		return nil, err
+	}
+
+	var waves []Apps
+	for _, indexes := range indexWaves {
+		wave := Apps{}
+		for _, i := range indexes {
+			wave = append(wave, a[i])
+		}
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
+}
+
+// RunOptions are options to running an action on all Apps.
+type RunOptions struct {
+	// Parallelism is the maximum number of Apps acted on concurrently.
+	Parallelism int
+	// KeepGoing continues with the remaining Apps after an App failed. Only
+	// Apps depending on failed Apps are skipped.
+	KeepGoing bool
+}
+
+// Errors aggregates the errors of multiple Apps.
+type Errors []error
+
+func (e Errors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Apply runs Apply on each App, wave by wave
+func (a Apps) Apply(config *RenderedConfig, options RunOptions) Results {
+	return a.run(options, "apply", false, func(app *App) (*[]interface{}, error) {
+		return app.Apply(config)
+	})
+}
+
+// DestroyOptions are options to destroying Apps.
+type DestroyOptions struct {
+	// Purge purges releases, freeing their names.
+	Purge bool
+	// ForceProtected destroys protected Apps too.
+	ForceProtected bool
+}
+
+// Destroy runs Destroy on each App in reverse order of Apply. Protected Apps
+// are skipped unless forced.
+func (a Apps) Destroy(destroyOptions DestroyOptions, options RunOptions) Results {
+	return a.run(options, "destroy", true, func(app *App) (*[]interface{}, error) {
+		if app.Protected && !destroyOptions.ForceProtected {
+			return nil, &SkipError{Reason: "protected"}
+		}
+
+		return app.Destroy(destroyOptions.Purge)
+	})
+}
+
+// Simulate runs Simulate on each App, wave by wave
+func (a Apps) Simulate(config *RenderedConfig, options RunOptions) Results {
+	return a.run(options, "simulate", false, func(app *App) (*[]interface{}, error) {
+		return app.Simulate(config)
+	})
+}
+
+// Render renders each App and writes its overrides to "<ID>.yaml" in
+// outputDir, without running Helm. Secrets are masked unless printing them is
+// allowed.
+func (a Apps) Render(config *RenderedConfig, outputDir string, options RunOptions) Results {
+	return a.run(options, "render", false, func(app *App) (*[]interface{}, error) {
+		rendered, err := app.Render(config)
 		if err != nil {
-			app.log.WithFields(logrus.Fields{
-				"app":   app.Name,
-				"cmd":   cmd,
-				"error": err,
-			}).Fatal("Failed running apply")
+			return nil, err
+		}
 
-			return err
+		file := filepath.Join(outputDir, app.ID+".yaml")
+		app.log.WithFields(logrus.Fields{
+			"chart":   rendered.Chart,
+			"version": rendered.Version,
+			"file":    file,
+		}).Info("Writing rendered app")
+
+		return nil, ioutil.WriteFile(file, []byte(app.printable(config, rendered.Overrides)), 0644)
+	})
+}
+
+// Validate renders each App and validates its overrides against its chart's
+// values schema, without running Helm.
+func (a Apps) Validate(config *RenderedConfig, options RunOptions) Results {
+	return a.run(options, "validate", false, func(app *App) (*[]interface{}, error) {
+		rendered, err := app.Render(config)
+		if err != nil {
+			return nil, err
 		}
 
+		return nil, app.ValidateValues(rendered)
+	})
+}
+
+// Diff runs Diff on each App and returns the AppDiffs of all Apps that did
+// not fail, in the order of the Results.
+func (a Apps) Diff(config *RenderedConfig, options RunOptions) ([]*AppDiff, Results) {
+	var mutex sync.Mutex
+	diffs := map[string]*AppDiff{}
+
+	results := a.run(options, "diff", false, func(app *App) (*[]interface{}, error) {
+		diff, cmd, err := app.Diff(config)
+		if err != nil {
+			return cmd, err
+		}
+		diff.Context = app.TargetContext
+
+		mutex.Lock()
+		diffs[app.TargetContext+"/"+app.ID] = diff
+		mutex.Unlock()
+
+		return cmd, nil
+	})
+
+	var ordered []*AppDiff
+	for _, result := range results {
+		if diff, ok := diffs[result.Context+"/"+result.App]; ok {
+			ordered = append(ordered, diff)
+		}
 	}
 
-	return nil
+	return ordered, results
 }
 
-// Destroy runs Destroy on each App
-func (a Apps) Destroy() error {
-	for _, app := range a {
-		cmd, err := app.Destroy(false)
+// Drift runs Drift on each App and returns the AppDrifts of all Apps that did
+// not fail, in the order of the Results. If orphans is set, the mh-managed
+// releases in each cluster without an App follow the Apps of the cluster.
+func (a Apps) Drift(config *RenderedConfig, options RunOptions, orphans bool) (AppDrifts, Results) {
+	var mutex sync.Mutex
+	drifts := map[string]*AppDrift{}
+
+	results := a.run(options, "drift", false, func(app *App) (*[]interface{}, error) {
+		drift, cmd, err := app.Drift(config)
 		if err != nil {
-			app.log.WithFields(logrus.Fields{
-				"app":   app.Name,
-				"cmd":   cmd,
-				"error": err,
-			}).Fatal("Failed running destroy")
+			return cmd, err
+		}
+
+		mutex.Lock()
+		drifts[app.TargetContext+"/"+app.ID] = drift
+		mutex.Unlock()
 
-			return err
+		return cmd, nil
+	})
+
+	var ordered AppDrifts
+	for _, cluster := range a.clusters() {
+		for _, result := range results {
+			if drift, ok := drifts[result.Context+"/"+result.App]; ok && result.Context == cluster[0].TargetContext {
+				ordered = append(ordered, drift)
+			}
+		}
+		if !orphans {
+			continue
 		}
+
+		clusterOrphans, cmd, err := cluster.orphans()
+		if err != nil {
+			result := Result{App: "orphans", Context: cluster[0].TargetContext, Action: "drift", Error: err}
+			if cmd != nil {
+				result.Cmd = *cmd
+			}
+			results = append(results, result)
+			continue
+		}
+		ordered = append(ordered, clusterOrphans...)
 	}
 
-	return nil
+	return ordered, results
 }
 
-// Simulate runs Simulate on each App
-func (a Apps) Simulate(configFile string) error {
-	for _, app := range a {
-		cmd, err := app.Simulate(configFile)
+// History returns the history of each App that did not fail, in the order of
+// the Results.
+func (a Apps) History(max int, options RunOptions) (AppHistories, Results) {
+	var mutex sync.Mutex
+	histories := map[string]*AppHistory{}
+
+	results := a.run(options, "history", false, func(app *App) (*[]interface{}, error) {
+		history, cmd, err := app.History(max)
 		if err != nil {
-			app.log.WithFields(logrus.Fields{
-				"app":   app.Name,
-				"cmd":   cmd,
-				"error": err,
-			}).Fatal("Failed running simulate")
+			return cmd, err
+		}
 
-			return err
+		mutex.Lock()
+		histories[app.TargetContext+"/"+app.ID] = history
+		mutex.Unlock()
+
+		return cmd, nil
+	})
+
+	var ordered AppHistories
+	for _, result := range results {
+		if history, ok := histories[result.Context+"/"+result.App]; ok {
+			ordered = append(ordered, history)
 		}
 	}
 
-	return nil
+	return ordered, results
+}
+
+// Provenance returns the provenance recorded in the release of each App that
+// did not fail, in the order of the Results.
+func (a Apps) Provenance(options RunOptions) (AppProvenances, Results) {
+	var mutex sync.Mutex
+	provenances := map[string]*AppProvenance{}
+
+	results := a.run(options, "provenance", false, func(app *App) (*[]interface{}, error) {
+		provenance, cmd, err := app.Provenance()
+		if err != nil {
+			return cmd, err
+		}
+
+		mutex.Lock()
+		provenances[app.TargetContext+"/"+app.ID] = provenance
+		mutex.Unlock()
+
+		return cmd, nil
+	})
+
+	var ordered AppProvenances
+	for _, result := range results {
+		if provenance, ok := provenances[result.Context+"/"+result.App]; ok {
+			ordered = append(ordered, provenance)
+		}
+	}
+
+	return ordered, results
+}
+
+// Rollback runs Rollback on each App in reverse order of Apply
+func (a Apps) Rollback(revision int, options RunOptions) Results {
+	return a.run(options, "rollback", true, func(app *App) (*[]interface{}, error) {
+		return app.Rollback(revision)
+	})
+}
+
+// Status runs Status on each App
+func (a Apps) Status(options RunOptions) Results {
+	return a.run(options, "status", false, func(app *App) (*[]interface{}, error) {
+		return nil, app.Status()
+	})
+}
+
+// FanOut returns a copy of each App for every context it targets, grouped by
+// context in the order the contexts first appear. If context is not empty,
+// all Apps target only it instead. Each copy operates on its own context.
+func (a Apps) FanOut(context string) (Apps, error) {
+	var contexts []string
+	byContext := map[string]Apps{}
+	for _, app := range a {
+		appContexts := app.Contexts()
+		if context != "" {
+			appContexts = []string{context}
+			app.ContextMode = ContextModeExplicit
+			app.TargetContexts = nil
+		}
+
+		for _, appContext := range appContexts {
+			if _, ok := byContext[appContext]; !ok {
+				contexts = append(contexts, appContext)
+			}
+
+			clone := app
+			clone.TargetContext = appContext
+			clone.log = app.log.WithField("context", appContext)
+			if err := clone.connect(); err != nil {
+				return nil, err
+			}
+
+			byContext[appContext] = append(byContext[appContext], clone)
+		}
+	}
+
+	var fannedOut Apps
+	for _, appContext := range contexts {
+		fannedOut = append(fannedOut, byContext[appContext]...)
+	}
+
+	return fannedOut, nil
+}
+
+// clusters groups the Apps by their TargetContext, in the order the contexts
+// first appear.
+func (a Apps) clusters() []Apps {
+	var contexts []string
+	byContext := map[string]Apps{}
+	for _, app := range a {
+		if _, ok := byContext[app.TargetContext]; !ok {
+			contexts = append(contexts, app.TargetContext)
+		}
+		byContext[app.TargetContext] = append(byContext[app.TargetContext], app)
+	}
+
+	var clusters []Apps
+	for _, context := range contexts {
+		clusters = append(clusters, byContext[context])
+	}
+
+	return clusters
+}
+
+// run runs an action on the Apps of each cluster one cluster after another.
+// Once a cluster failed, the Apps of the remaining clusters are skipped unless
+// options.KeepGoing is set. Results are grouped by cluster.
+func (a Apps) run(options RunOptions, action string, reverse bool, fn func(app *App) (*[]interface{}, error)) Results {
+	var results Results
+	for _, cluster := range a.clusters() {
+		if results.Failed() && !options.KeepGoing {
+			for _, app := range cluster {
+				results = append(results, Result{
+					App:     app.ID,
+					Context: app.TargetContext,
+					Action:  action,
+					Error:   fmt.Errorf("Skipped after previous failure"),
+					Skipped: true,
+				})
+			}
+			continue
+		}
+
+		results = append(results, cluster.runCluster(options, action, reverse, fn)...)
+	}
+
+	return results
+}
+
+// runCluster runs an action on each App wave by wave, in reverse order if requested.
+// Up to options.Parallelism Apps of a wave run concurrently. Failures are
+// logged with the failing App. Once an App failed, no further Apps are started
+// unless options.KeepGoing is set, in which case only Apps waiting for the
+// failed App are skipped. Returns a Result for every App.
+func (a Apps) runCluster(options RunOptions, action string, reverse bool, fn func(app *App) (*[]interface{}, error)) Results {
+	graph, _ := newAppGraph(a.configs())
+	waves, err := graph.waves()
+	if err != nil {
+		var results Results
+		for _, app := range a {
+			results = append(results, Result{App: app.ID, Context: app.TargetContext, Action: action, Error: err})
+		}
+		return results
+	}
+
+	// Apps have to wait for the Apps they depend on, or for the Apps depending
+	// on them in reverse order.
+	waitFor := graph.deps
+	if reverse {
+		waitFor = make([][]int, len(a))
+		for i, deps := range graph.deps {
+			for _, dep := range deps {
+				waitFor[dep] = append(waitFor[dep], i)
+			}
+		}
+
+		for w := 0; w < len(waves)/2; w++ {
+			waves[w], waves[len(waves)-1-w] = waves[len(waves)-1-w], waves[w]
+		}
+		for _, wave := range waves {
+			for i := 0; i < len(wave)/2; i++ {
+				wave[i], wave[len(wave)-1-i] = wave[len(wave)-1-i], wave[i]
+			}
+		}
+	}
+
+	parallelism := options.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var (
+		order     []int
+		failed    bool
+		mutex     sync.Mutex
+		results   = make(Results, len(a))
+		semaphore = make(chan bool, parallelism)
+	)
+	for _, wave := range waves {
+		var wg sync.WaitGroup
+
+		for _, i := range wave {
+			order = append(order, i)
+			semaphore <- true
+
+			// Decide whether to skip the App while holding the lock, as
+			// results are written concurrently.
+			mutex.Lock()
+			results[i] = Result{App: a[i].ID, Context: a[i].TargetContext, Action: action}
+			if failed && !options.KeepGoing {
+				results[i].Skipped = true
+				results[i].Error = fmt.Errorf("Skipped after previous failure")
+			}
+			for _, j := range waitFor[i] {
+				if results[j].failed() {
+					results[i].Skipped = true
+					results[i].Error = fmt.Errorf("Skipped as %s did not succeed", a[j].ID)
+					break
+				}
+			}
+			skipped := results[i].Skipped
+			mutex.Unlock()
+
+			if skipped {
+				a[i].log.WithField("reason", results[i].Error).Warnf("Skipped running %s", action)
+				<-semaphore
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int, app App) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+
+				// Buffer Helm's output of concurrently running Apps and print
+				// it at once, so it is not interleaved with that of others.
+				var output bytes.Buffer
+				setter, buffered := app.Backend.(outputSetter)
+				if buffered && parallelism > 1 {
+					setter.setOutput(&output)
+					defer setter.setOutput(nil)
+				}
+
+				start := time.Now()
+				cmd, err := fn(&app)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				writePrefixed(os.Stdout, app.outputPrefix(), output.Bytes())
+
+				results[i].Duration = time.Since(start)
+				if cmd != nil {
+					results[i].Cmd = *cmd
+				}
+				if skipErr, ok := err.(*SkipError); ok {
+					app.log.WithField("reason", skipErr).Warnf("Skipped running %s", action)
+					results[i].Error = skipErr
+					results[i].Skipped = true
+				} else if err != nil {
+					app.log.WithFields(logrus.Fields{
+						"app":   app.Name,
+						"cmd":   cmd,
+						"error": err,
+					}).Errorf("Failed running %s", action)
+
+					results[i].Error = err
+					if rollbackErr, ok := err.(*RollbackError); ok {
+						results[i].Rollback = rollbackErr.Outcome
+					}
+					failed = true
+				}
+			}(i, a[i])
+		}
+
+		wg.Wait()
+	}
+
+	var ordered Results
+	for _, i := range order {
+		ordered = append(ordered, results[i])
+	}
+
+	return ordered
+}
+
+// outputPrefix returns what lines of Helm's output of an App are prefixed with
+// when Apps run concurrently.
+func (a *App) outputPrefix() string {
+	if a.TargetContext == "" {
+		return a.ID + ": "
+	}
+	return a.TargetContext + "/" + a.ID + ": "
+}
+
+// writePrefixed writes each line of output to w, prefixed with prefix.
+func writePrefixed(w io.Writer, prefix string, output []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fmt.Fprintf(w, "%s%s\n", prefix, scanner.Text())
+	}
 }
@@ -0,0 +1,409 @@
+package mhlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testConfig = `
+apps:
+- name: foo
+- name: bar
+  alias: baz
+appSources:
+- name: apps
+  kind: configPath
+  source: apps
+foo:
+  tag: "1.0"
+baz:
+  tag: "2.0"
+`
+
+const testAppFile = `
+chart: stable/{{ $name }}
+version: 0.1.0
+image:
+  tag: {{ $app.tag | quote }}
+`
+
+// newTestApps writes a mh configuration with the apps foo and baz (an alias of
+// bar) to a temporary directory and returns its effective apps, all using the
+// same FakeBackend and FakeKubeClient, and the rendered configuration.
+func newTestApps(t *testing.T, mhConfigFile MHConfigFile) (Apps, *RenderedConfig, *FakeBackend) {
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "apps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "main.yaml")
+	files := map[string]string{
+		configFile:                             testConfig,
+		filepath.Join(dir, "apps", "foo.yaml"): testAppFile,
+		filepath.Join(dir, "apps", "bar.yaml"): testAppFile,
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	apps, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), configFile, Selector{}, false, DefaultMHConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewFakeBackend()
+	kube := NewFakeKubeClient()
+	for i := range *apps {
+		(*apps)[i].Backend = backend
+		(*apps)[i].Kube = kube
+	}
+
+	config, err := RenderConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *apps, config, backend
+}
+
+var testMHConfigFile = MHConfigFile{
+	Apps: AppConfigs{
+		{Name: "foo"},
+		{Name: "bar", Alias: "baz"},
+	},
+	AppSources: AppSourceConfigs{
+		{Name: "apps", Kind: "configPath", Source: "apps"},
+	},
+}
+
+func TestAppsApply(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Upgrade foo", "Upgrade baz"}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", methods, expected)
+	}
+
+	options := backend.Calls[1].Options
+	if options.Chart != "stable/baz" || options.Version != "0.1.0" || options.DryRun {
+		t.Fatalf("Unexpected upgrade options: %+v", options)
+	}
+	if !strings.Contains(string(options.Values), `tag: "2.0"`) {
+		t.Fatalf("Unexpected values: %s", options.Values)
+	}
+	if len(backend.Releases["baz"]) != 1 {
+		t.Fatalf("Release baz not installed: %v", backend.Releases)
+	}
+}
+
+func TestAppsSimulate(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	if err := apps.Simulate(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, call := range backend.Calls {
+		if !call.Options.DryRun {
+			t.Fatalf("Simulate did not dry-run %s", call.Release)
+		}
+	}
+	if len(backend.Releases) != 0 {
+		t.Fatalf("Simulate installed releases: %v", backend.Releases)
+	}
+}
+
+func TestAppsDestroy(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := apps.Destroy(DestroyOptions{}, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Upgrade foo", "Upgrade baz", "Delete baz", "Delete foo"}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", methods, expected)
+	}
+	if status := backend.Releases["foo"][0].Status; status != "DELETED" {
+		t.Fatalf("Release foo has status %s", status)
+	}
+}
+
+func TestAppsDestroyProtected(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	apps[1].Protected = true
+
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Protected apps are skipped without failing, the remaining ones are
+	// destroyed
+	results := apps.Destroy(DestroyOptions{Purge: true}, RunOptions{})
+	if results.Failed() || results[0].Status() != "skipped" || results[0].Error.Error() != "protected" || results[1].Status() != "ok" {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	if _, ok := backend.Releases["baz"]; !ok || len(backend.Releases) != 1 {
+		t.Fatalf("Unexpected releases: %v", backend.Releases)
+	}
+
+	// Forcing destroys protected apps, purge forgets their releases
+	if err := apps[1:].Destroy(DestroyOptions{Purge: true, ForceProtected: true}, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.Releases) != 0 {
+		t.Fatalf("Releases were not purged: %v", backend.Releases)
+	}
+}
+
+func TestAppsApplyParallel(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	backend.Errors["Upgrade baz"] = errors.New("boom")
+
+	err := apps.Apply(config, RunOptions{Parallelism: 2}).Err()
+	if errs, ok := err.(Errors); !ok || len(errs) != 1 || errs[0].Error() != "baz: boom" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The failure of baz does not affect foo running alongside it
+	if len(backend.Calls) != 2 || len(backend.Releases["foo"]) != 1 {
+		t.Fatalf("Unexpected calls: %v", backend.Methods())
+	}
+}
+
+// printingBackend is a FakeBackend printing Helm-like output on upgrades.
+type printingBackend struct {
+	*FakeBackend
+	out io.Writer
+}
+
+func (b *printingBackend) setOutput(out io.Writer) {
+	b.out = out
+}
+
+func (b *printingBackend) Upgrade(options UpgradeOptions) (*[]interface{}, error) {
+	out := b.out
+	if out == nil {
+		out = os.Stdout
+	}
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(out, "upgrading %s %d\n", options.Release, i)
+		time.Sleep(10 * time.Millisecond)
+	}
+	return b.FakeBackend.Upgrade(options)
+}
+
+func TestAppsApplyParallelOutput(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	for i := range apps {
+		apps[i].Backend = &printingBackend{FakeBackend: backend}
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	err = apps.Apply(config, RunOptions{Parallelism: 2}).Err()
+	os.Stdout = stdout
+	writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The output of each App is printed at once, prefixed with its context
+	// and ID
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Unexpected output: %q", output)
+	}
+	for _, group := range [][]string{lines[:3], lines[3:]} {
+		prefix := strings.SplitN(group[0], ": ", 2)[0]
+		id := strings.TrimPrefix(prefix, "localhost/")
+		for i, line := range group {
+			if line != fmt.Sprintf("localhost/%s: upgrading %s %d", id, id, i+1) {
+				t.Errorf("Unexpected output: %q", output)
+			}
+		}
+	}
+}
+
+func TestAppsApplyKeepGoing(t *testing.T) {
+	for _, keepGoing := range []bool{false, true} {
+		apps, config, backend := newTestApps(t, testMHConfigFile)
+		defer os.RemoveAll(filepath.Dir(config.File))
+
+		backend.Errors["Upgrade foo"] = errors.New("boom")
+
+		results := apps.Apply(config, RunOptions{KeepGoing: keepGoing})
+		if !results.Failed() || len(results) != 2 {
+			t.Fatalf("Unexpected results: %v", results)
+		}
+
+		expected := []string{"failed", "skipped"}
+		if keepGoing {
+			expected = []string{"failed", "ok"}
+		}
+		for i, result := range results {
+			if result.Status() != expected[i] {
+				t.Fatalf("keepGoing %v: %s is %s, expected %s", keepGoing, result.App, result.Status(), expected[i])
+			}
+		}
+	}
+}
+
+func TestAppsApplySkipsDependents(t *testing.T) {
+	mhConfigFile := MHConfigFile{
+		Apps: AppConfigs{
+			{Name: "foo", DependsOn: []string{"baz"}},
+			{Name: "bar", Alias: "baz"},
+		},
+		AppSources: testMHConfigFile.AppSources,
+	}
+	apps, config, backend := newTestApps(t, mhConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	backend.Errors["Upgrade baz"] = errors.New("boom")
+
+	results := apps.Apply(config, RunOptions{KeepGoing: true})
+	if results[0].App != "baz" || results[0].Status() != "failed" || results[1].Status() != "skipped" {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+	if methods := backend.Methods(); len(methods) != 1 {
+		t.Fatalf("Dependent of failed app was applied: %v", methods)
+	}
+}
+
+func TestAppsRender(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	outputDir := filepath.Join(filepath.Dir(config.File), "rendered")
+	if err := os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := apps.Render(config, outputDir, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.Calls) != 0 {
+		t.Fatalf("Render called the backend: %v", backend.Methods())
+	}
+
+	out, err := ioutil.ReadFile(filepath.Join(outputDir, "baz.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "chart: stable/baz") || !strings.Contains(string(out), "version: 0.1.0") {
+		t.Fatalf("Unexpected rendered app: %s", out)
+	}
+}
+
+func TestRenderedConfigValues(t *testing.T) {
+	_, config, _ := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	values := config.Values()
+	values["foo"] = "changed"
+
+	// Values are copied, so apps can not affect each other
+	values = config.Values()
+	if tag, err := values.PathValue("foo.tag"); err != nil || tag != "1.0" {
+		t.Fatalf("Unexpected foo.tag: %v (%v)", tag, err)
+	}
+}
+
+func TestAppsFanOut(t *testing.T) {
+	mhConfigFile := MHConfigFile{
+		Apps: AppConfigs{
+			{Name: "foo", MHConfig: MHConfig{TargetContexts: []string{"a", "b"}}},
+			{Name: "bar", Alias: "baz", MHConfig: MHConfig{TargetContext: "b"}},
+		},
+		AppSources: testMHConfigFile.AppSources,
+	}
+	apps, config, _ := newTestApps(t, mhConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	fannedOut, err := apps.FanOut("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, app := range fannedOut {
+		actual = append(actual, app.ID+" in "+app.TargetContext+" via "+app.Backend.(*HelmV2Backend).kube.Context)
+	}
+	expected := []string{"foo in a via a", "foo in b via b", "baz in b via "}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", actual, expected)
+	}
+
+	// Clusters run one after another, the second one is skipped after the
+	// first one failed
+	backends := map[string]*FakeBackend{"a": NewFakeBackend(), "b": NewFakeBackend()}
+	for i := range fannedOut {
+		fannedOut[i].Backend = backends[fannedOut[i].TargetContext]
+	}
+	backends["a"].Errors["Upgrade foo"] = errors.New("boom")
+
+	results := fannedOut.Apply(config, RunOptions{})
+	actual = nil
+	for _, result := range results {
+		actual = append(actual, result.Context+"/"+result.App+" "+result.Status())
+	}
+	expected = []string{"a/foo failed", "b/foo skipped", "b/baz skipped"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", actual, expected)
+	}
+
+	// A context given on the command line replaces all contexts
+	fannedOut, err = apps.FanOut("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, app := range fannedOut {
+		if app.TargetContext != "c" || app.Backend.(*HelmV2Backend).kube.Context != "c" {
+			t.Fatalf("App %s targets %s", app.ID, app.TargetContext)
+		}
+	}
+	if len(fannedOut) != 2 {
+		t.Fatalf("Unexpected apps: %d", len(fannedOut))
+	}
+}
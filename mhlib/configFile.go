@@ -0,0 +1,317 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// LoadConfigFile reads a mh configuration file and composes it with the files
+// listed in its "include" and "overlays" keys, before self-rendering. Both the
+// configuration mh unmarshals and the one apps are rendered with are loaded
+// this way.
+//
+// Included files are merged beneath the file, overlays on top of it, each in
+// the order they are listed. Their paths are relative to the file listing
+// them, and they may include and overlay other files themselves. A file
+// without "include" and "overlays" is returned as is.
+//
+// Merging works as follows:
+//
+// Maps are merged recursively. A null value removes the key.
+//
+// Lists whose items all are maps with a "name" are merged by item: items with
+// the same "alias", or "name" if they have no alias, are merged recursively,
+// others are appended. This applies to "apps" and "appSources".
+//
+// All other values, including other lists, are replaced.
+func LoadConfigFile(configFile string) ([]byte, error) {
+	layers, err := LoadConfigLayers(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return composeConfigLayers(layers)
+}
+
+// ConfigLayer is one of the files a mh configuration is composed of.
+type ConfigLayer struct {
+	File     string
+	Data     []byte
+	document map[string]interface{}
+}
+
+// LoadConfigLayers returns the files a mh configuration file is composed of in
+// the order they are merged. Includes and overlays of included and overlaid
+// files are expanded in place.
+func LoadConfigLayers(configFile string) ([]ConfigLayer, error) {
+	return loadConfigLayers(configFile, nil)
+}
+
+// loadConfigLayers loads a configuration file and everything it includes and
+// overlays. stack holds the files currently loading to detect cycles.
+func loadConfigLayers(configFile string, stack []string) ([]ConfigLayer, error) {
+	absFile, err := filepath.Abs(configFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range stack {
+		if file == absFile {
+			return nil, fmt.Errorf("Config file %s includes itself", configFile)
+		}
+	}
+	stack = append(stack, absFile)
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read configFile %v: %v", configFile, err)
+	}
+
+	document := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("Failed to parse configFile %v: %v", configFile, err)
+	}
+
+	includes, err := configPaths(document, "include", configFile)
+	if err != nil {
+		return nil, err
+	}
+	overlays, err := configPaths(document, "overlays", configFile)
+	if err != nil {
+		return nil, err
+	}
+	delete(document, "include")
+	delete(document, "overlays")
+
+	var layers []ConfigLayer
+	for _, file := range includes {
+		included, err := loadConfigLayers(file, stack)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, included...)
+	}
+	layers = append(layers, ConfigLayer{configFile, data, document})
+	for _, file := range overlays {
+		overlaid, err := loadConfigLayers(file, stack)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, overlaid...)
+	}
+
+	return layers, nil
+}
+
+// composeConfigLayers merges ConfigLayers into a single document. A single
+// layer is returned as is.
+func composeConfigLayers(layers []ConfigLayer) ([]byte, error) {
+	if len(layers) == 1 {
+		return layers[0].Data, nil
+	}
+
+	var merged interface{} = map[string]interface{}{}
+	for _, layer := range layers {
+		merged = mergeConfigValues(merged, layer.document)
+	}
+
+	return yaml.Marshal(merged)
+}
+
+// configPaths returns the paths listed at key of a configuration document,
+// relative to the directory of its file.
+func configPaths(document map[string]interface{}, key, configFile string) ([]string, error) {
+	value, ok := document[key]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s of configFile %v must be a list of paths", key, configFile)
+	}
+
+	var paths []string
+	for _, item := range list {
+		path, ok := item.(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("%s of configFile %v must be a list of paths", key, configFile)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configFile), path)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// mergeConfigValues merges overlay on top of base as documented at
+// LoadConfigFile. Neither of them is modified.
+func mergeConfigValues(base, overlay interface{}) interface{} {
+	switch overlay := overlay.(type) {
+	case map[string]interface{}:
+		baseMap, ok := base.(map[string]interface{})
+		if !ok {
+			baseMap = map[string]interface{}{}
+		}
+
+		merged := map[string]interface{}{}
+		for key, value := range baseMap {
+			merged[key] = value
+		}
+		for key, value := range overlay {
+			if value == nil {
+				delete(merged, key)
+				continue
+			}
+			merged[key] = mergeConfigValues(merged[key], value)
+		}
+
+		return merged
+	case []interface{}:
+		baseList, ok := base.([]interface{})
+		if !ok || !namedList(baseList) || !namedList(overlay) {
+			return overlay
+		}
+
+		merged := append([]interface{}{}, baseList...)
+		for _, item := range overlay {
+			found := false
+			for i, existing := range merged {
+				if namedItemKey(existing) == namedItemKey(item) {
+					merged[i] = mergeConfigValues(existing, item)
+					found = true
+					break
+				}
+			}
+			if !found {
+				merged = append(merged, item)
+			}
+		}
+
+		return merged
+	}
+
+	return overlay
+}
+
+// namedList returns true if a list is not empty and all its items are maps
+// with a "name".
+func namedList(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if namedItemKey(item) == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namedItemKey returns the "alias" of a list item, or its "name" if it has no
+// alias, the same way AppConfigs are identified.
+func namedItemKey(item interface{}) string {
+	itemMap, ok := item.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if alias, ok := itemMap["alias"].(string); ok && alias != "" {
+		return alias
+	}
+	name, _ := itemMap["name"].(string)
+
+	return name
+}
+
+// findLine returns the line of the value at path in a block style YAML
+// document, or of its closest parent if the rest is in flow style. Path
+// elements like "[2]" are indexes of sequences. Returns 0 if it is not found.
+func findLine(data []byte, path []string) int {
+	found, depth, items := 0, 0, 0
+	parentIndent, childIndent := -1, -1
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "---") {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+
+		// A sequence item's first line follows its dash, so a line may be
+		// looked at once for the item and once for its content.
+		for {
+			index, isIndex := pathIndex(path[depth])
+			dash := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+
+			// Stop at the end of the parent's block. Sequences may be
+			// indented as much as their parent key.
+			if indent < parentIndent || (indent == parentIndent && !(isIndex && dash)) {
+				return found
+			}
+			if childIndent == -1 {
+				childIndent = indent
+			}
+			if indent != childIndent {
+				break
+			}
+
+			matched := false
+			if isIndex && dash {
+				matched = items == index
+				items++
+			} else if !isIndex && !dash {
+				matched = yamlKey(trimmed) == path[depth]
+			}
+			if !matched {
+				break
+			}
+
+			found = i + 1
+			depth++
+			if depth == len(path) {
+				return found
+			}
+			parentIndent, childIndent, items = indent, -1, 0
+
+			content := strings.TrimLeft(strings.TrimPrefix(trimmed, "-"), " ")
+			if !isIndex || content == "" {
+				break
+			}
+			indent, trimmed = indent+len(trimmed)-len(content), content
+		}
+	}
+
+	return found
+}
+
+// yamlKey returns the key of a line of a YAML map, without quotes.
+func yamlKey(line string) string {
+	end := strings.Index(line, ": ")
+	if end == -1 {
+		if !strings.HasSuffix(line, ":") {
+			return ""
+		}
+		end = len(line) - 1
+	}
+
+	return strings.Trim(line[:end], `"'`)
+}
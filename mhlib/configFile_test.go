@@ -0,0 +1,138 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+// writeTestFiles writes files relative to a new temporary directory and
+// returns it.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"base.yaml": `
+mh:
+  team: sre
+  maintainers: [alice, bob]
+apps:
+- name: foo
+  namespace: default
+- name: bar
+appSources:
+- name: apps
+  kind: path
+  source: /apps
+foo:
+  replicas: 1
+  resources: {cpu: 1}
+`,
+		"regions/eu.yaml": `
+mh:
+  maintainers: [carol]
+apps:
+- name: bar
+  alias: baz
+foo:
+  replicas: 2
+`,
+		"clusters/prod/main.yaml": `
+include:
+- ../../base.yaml
+overlays:
+- ../../regions/eu.yaml
+- cluster.yaml
+apps:
+- name: foo
+  namespace: foo
+foo:
+  resources: null
+  image: '[[ .foo.replicas ]]'
+`,
+		"clusters/prod/cluster.yaml": `
+mh:
+  targetContext: prod
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	data, err := LoadConfigFile(filepath.Join(dir, "clusters/prod/main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual, expected interface{}
+	if err := yaml.Unmarshal(data, &actual); err != nil {
+		t.Fatal(err)
+	}
+	yaml.Unmarshal([]byte(`
+mh:
+  team: sre
+  maintainers: [carol]
+  targetContext: prod
+apps:
+- name: foo
+  namespace: foo
+- name: bar
+- name: bar
+  alias: baz
+appSources:
+- name: apps
+  kind: path
+  source: /apps
+foo:
+  replicas: 2
+  image: '[[ .foo.replicas ]]'
+`), &expected)
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nActual: %s\nExpected: %v\n", data, expected)
+	}
+}
+
+func TestLoadConfigFileUnchanged(t *testing.T) {
+	content := "# comment\nfoo: '[[ .bar ]]'\nbar: baz\n"
+	dir := writeTestFiles(t, map[string]string{"main.yaml": content})
+	defer os.RemoveAll(dir)
+
+	data, err := LoadConfigFile(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("\nActual: %s\nExpected: %s\n", data, content)
+	}
+}
+
+func TestLoadConfigFileCycle(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.yaml": "include: [b.yaml]\n",
+		"b.yaml": "overlays: [a.yaml]\n",
+	})
+	defer os.RemoveAll(dir)
+
+	if _, err := LoadConfigFile(filepath.Join(dir, "a.yaml")); err == nil {
+		t.Fatal("Expected an error for a cyclic include")
+	}
+}
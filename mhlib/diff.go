@@ -0,0 +1,350 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// ResourceDiff is the unified diff of a single Kubernetes resource, or of the
+// release values, between the deployed and the rendered state of an App.
+type ResourceDiff struct {
+	Resource string
+	Diff     string
+}
+
+// AppDiff is the difference between the deployed and the rendered state of an
+// App.
+type AppDiff struct {
+	App       string
+	Context   string
+	Installed bool
+	Resources []ResourceDiff
+}
+
+// Changed returns true if the App would change when applied.
+func (d *AppDiff) Changed() bool {
+	return len(d.Resources) > 0
+}
+
+// Print writes the diffs of all changed resources, colored if requested.
+func (d *AppDiff) Print(w io.Writer, color bool) {
+	const (
+		bold  = "\x1b[1m"
+		red   = "\x1b[31m"
+		green = "\x1b[32m"
+		cyan  = "\x1b[36m"
+		reset = "\x1b[0m"
+	)
+	paint := func(code, line string) string {
+		if !color {
+			return line
+		}
+		return code + line + reset
+	}
+
+	header := fmt.Sprintf("app %s", d.App)
+	if d.Context != "" {
+		header += " in " + d.Context
+	}
+	if !d.Installed {
+		header += " (not installed)"
+	}
+	fmt.Fprintln(w, paint(bold, header))
+
+	for _, resource := range d.Resources {
+		for _, line := range strings.Split(strings.TrimSuffix(resource.Diff, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+				line = paint(bold, line)
+			case strings.HasPrefix(line, "@@"):
+				line = paint(cyan, line)
+			case strings.HasPrefix(line, "-"):
+				line = paint(red, line)
+			case strings.HasPrefix(line, "+"):
+				line = paint(green, line)
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// newAppDiff compares the deployed values and manifest of an App with the
+// rendered ones.
+func newAppDiff(app string, installed bool, deployedValues, renderedValues []byte, deployedManifest, renderedManifest string) *AppDiff {
+	diff := &AppDiff{App: app, Installed: installed}
+
+	if d := unifiedDiff(normalizeYAML(deployedValues), normalizeYAML(renderedValues), "deployed values", "rendered values"); d != "" {
+		diff.Resources = append(diff.Resources, ResourceDiff{"values", d})
+	}
+
+	deployed := splitManifest(deployedManifest)
+	rendered := splitManifest(renderedManifest)
+	maskSecretData(deployed, rendered)
+
+	var resources []string
+	for resource := range deployed {
+		resources = append(resources, resource)
+	}
+	for resource := range rendered {
+		if _, ok := deployed[resource]; !ok {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Strings(resources)
+
+	for _, resource := range resources {
+		d := unifiedDiff(deployed[resource], rendered[resource], "deployed "+resource, "rendered "+resource)
+		if d != "" {
+			diff.Resources = append(diff.Resources, ResourceDiff{resource, d})
+		}
+	}
+
+	return diff
+}
+
+// maskSecretData masks the data of Kubernetes Secrets in split manifests like
+// `kubectl diff` does: values are "***", or "*** (before)" and "*** (after)"
+// if they changed.
+func maskSecretData(deployed, rendered map[string]string) {
+	parse := func(resources map[string]string, resource string) map[string]interface{} {
+		document := map[string]interface{}{}
+		if content, ok := resources[resource]; ok {
+			yaml.Unmarshal([]byte(content), &document)
+		}
+		return document
+	}
+	format := func(document map[string]interface{}) string {
+		out, err := yaml.Marshal(document)
+		if err != nil {
+			return ""
+		}
+		return string(out)
+	}
+
+	resources := map[string]bool{}
+	for resource := range deployed {
+		resources[resource] = true
+	}
+	for resource := range rendered {
+		resources[resource] = true
+	}
+
+	for resource := range resources {
+		if !strings.HasPrefix(resource, "Secret ") {
+			continue
+		}
+		before := parse(deployed, resource)
+		after := parse(rendered, resource)
+
+		for _, field := range []string{"data", "stringData"} {
+			beforeData, _ := before[field].(map[string]interface{})
+			afterData, _ := after[field].(map[string]interface{})
+			for key, beforeValue := range beforeData {
+				afterValue, ok := afterData[key]
+				if ok && beforeValue != afterValue {
+					beforeData[key] = "*** (before)"
+					afterData[key] = "*** (after)"
+				} else {
+					beforeData[key] = "***"
+					if ok {
+						afterData[key] = "***"
+					}
+				}
+			}
+			for key := range afterData {
+				if _, ok := beforeData[key]; !ok {
+					afterData[key] = "***"
+				}
+			}
+		}
+
+		if _, ok := deployed[resource]; ok {
+			deployed[resource] = format(before)
+		}
+		if _, ok := rendered[resource]; ok {
+			rendered[resource] = format(after)
+		}
+	}
+}
+
+// normalizeYAML re-marshals a YAML document to get rid of comments,
+// formatting and key order. Invalid YAML is returned as is.
+func normalizeYAML(data []byte) string {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return string(data)
+	}
+	if value == nil {
+		return ""
+	}
+
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return string(data)
+	}
+
+	return string(out)
+}
+
+// splitManifest splits a manifest into its normalized resources, keyed by
+// "<kind> [<namespace>/]<name>".
+func splitManifest(manifest string) map[string]string {
+	resources := map[string]string{}
+
+	separator := regexp.MustCompile(`(?m)^---.*$`)
+	for _, document := range separator.Split(manifest, -1) {
+		var resource struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(document), &resource); err != nil || resource.Kind == "" {
+			continue
+		}
+
+		name := resource.Metadata.Name
+		if resource.Metadata.Namespace != "" {
+			name = resource.Metadata.Namespace + "/" + name
+		}
+		resources[resource.Kind+" "+name] = normalizeYAML([]byte(document))
+	}
+
+	return resources
+}
+
+// unifiedDiff returns a unified diff with three lines of context between two
+// texts, or an empty string if they are equal.
+func unifiedDiff(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+
+	const context = 3
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Build the edit script, remembering the line indexes in a and b at which
+	// each operation happens.
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	out := fmt.Sprintf("--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(edits); {
+		// Find the next change
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// Extend the hunk as long as changes are close enough together
+		end := start
+		for k := start; k < len(edits) && k <= end+2*context; k++ {
+			if edits[k].op != ' ' {
+				end = k
+			}
+		}
+
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		last := end + context
+		if last >= len(edits) {
+			last = len(edits) - 1
+		}
+
+		var body string
+		fromCount, toCount := 0, 0
+		for _, e := range edits[first : last+1] {
+			body += string(e.op) + e.line + "\n"
+			if e.op != '+' {
+				fromCount++
+			}
+			if e.op != '-' {
+				toCount++
+			}
+		}
+
+		fromStart, toStart := edits[first].i, edits[first].j
+		if fromCount > 0 {
+			fromStart++
+		}
+		if toCount > 0 {
+			toStart++
+		}
+		out += fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount) + body
+
+		start = last + 1
+	}
+
+	return out
+}
+
+// splitLines splits a text into lines without their line breaks.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
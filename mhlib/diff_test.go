@@ -0,0 +1,93 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	to := "a\nb\nc\nD\ne\nf\ng\nh\ni\n"
+
+	expected := `--- from
++++ to
+@@ -1,8 +1,9 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+ h
++i
+`
+	if out := unifiedDiff(from, to, "from", "to"); out != expected {
+		t.Fatalf("\nActual: %s\nExpected: %s\n", out, expected)
+	}
+	if out := unifiedDiff(from, from, "from", "to"); out != "" {
+		t.Fatalf("Equal texts differ: %s", out)
+	}
+}
+
+func TestSplitManifest(t *testing.T) {
+	manifest := `---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: bar
+---
+kind: Deployment
+metadata: {name: foo}
+`
+	resources := splitManifest(manifest)
+	if len(resources) != 2 || resources["Service bar/foo"] == "" || resources["Deployment foo"] == "" {
+		t.Fatalf("Unexpected resources: %v", resources)
+	}
+}
+
+func TestAppsDiff(t *testing.T) {
+	apps, config, _ := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	// Not installed apps are all new
+	diffs, results := apps.Diff(config, RunOptions{})
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 || diffs[0].Installed || !diffs[0].Changed() {
+		t.Fatalf("Unexpected diffs of not installed apps: %+v", diffs)
+	}
+
+	// Applied apps are unchanged
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	diffs, _ = apps.Diff(config, RunOptions{})
+	if diffs[0].Changed() || diffs[1].Changed() {
+		t.Fatalf("Unexpected diffs of applied apps: %+v", diffs)
+	}
+
+	// Changing the config changes values and resources
+	contents := strings.Replace(testConfig, `tag: "2.0"`, `tag: "2.1"`, 1)
+	if err := ioutil.WriteFile(config.File, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := RenderConfig(config.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, _ = apps.Diff(config, RunOptions{})
+	if diffs[0].Changed() || !diffs[1].Changed() || len(diffs[1].Resources) != 2 {
+		t.Fatalf("Unexpected diffs of changed apps: %+v", diffs)
+	}
+	if !strings.Contains(diffs[1].Resources[0].Diff, "+  tag: \"2.1\"") || diffs[1].Resources[1].Resource != "ConfigMap baz" {
+		t.Fatalf("Unexpected diff: %+v", diffs[1].Resources)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+)
+
+// Drift statuses of Apps and orphaned releases.
+const (
+	DriftInSync       = "in sync"
+	DriftDrifted      = "drifted"
+	DriftNotInstalled = "not installed"
+	DriftOrphan       = "orphan"
+)
+
+// AppDrift is how the deployed release of an App differs from the App's
+// rendered state. Orphans are mh-managed releases without an App.
+type AppDrift struct {
+	App           string `json:"app"`
+	Context       string `json:"context"`
+	Status        string `json:"status"`
+	DeployedChart string `json:"deployedChart,omitempty"`
+	RenderedChart string `json:"renderedChart,omitempty"`
+	ValuesDiff    string `json:"valuesDiff,omitempty"`
+}
+
+// Drifted returns true unless the App's release is in sync.
+func (d *AppDrift) Drifted() bool {
+	return d.Status != DriftInSync
+}
+
+// Drift compares the rendered overrides and chart version of the App with the
+// values and chart of its deployed release. Unlike Diff, it does not render
+// manifests, so charts are not fetched.
+func (a *App) Drift(config *RenderedConfig) (*AppDrift, *[]interface{}, error) {
+	rendered, err := a.Render(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drift := &AppDrift{
+		App:           a.ID,
+		Context:       a.TargetContext,
+		Status:        DriftInSync,
+		RenderedChart: chartName(rendered.Chart, rendered.Version),
+	}
+
+	deployedValues, cmd, err := a.Backend.GetValues(a.ID, a.Namespace, 0)
+	if err == ErrReleaseNotFound {
+		drift.Status = DriftNotInstalled
+		return drift, nil, nil
+	} else if err != nil {
+		return nil, cmd, err
+	}
+
+	revisions, cmd, err := a.Backend.History(a.ID, a.Namespace)
+	if err != nil {
+		return nil, cmd, err
+	}
+	drift.DeployedChart = deployedChart(revisions)
+	if rendered.Version != "" && !strings.HasSuffix(drift.DeployedChart, "-"+rendered.Version) {
+		drift.Status = DriftDrifted
+	}
+
+	// Deployed values may hold former sensitive values
+	var deployed interface{}
+	if err := yaml.Unmarshal(deployedValues, &deployed); err == nil {
+		config.Redactor.addSensitive(deployed, a.SensitiveKeys)
+	}
+
+	renderedValues := rendered.Overrides
+	if !a.PrintSecrets {
+		deployedValues, renderedValues = maskSensitiveYAML(deployedValues, renderedValues, a.SensitiveKeys)
+		deployedValues, renderedValues = config.Redactor.redactYAML(deployedValues), config.Redactor.redactYAML(renderedValues)
+	}
+
+	drift.ValuesDiff = unifiedDiff(normalizeYAML(deployedValues), normalizeYAML(renderedValues), "deployed values", "rendered values")
+	if drift.ValuesDiff != "" {
+		drift.Status = DriftDrifted
+		if !a.PrintSecrets {
+			drift.ValuesDiff = config.Redactor.Redact(drift.ValuesDiff)
+		}
+	}
+
+	return drift, nil, nil
+}
+
+// chartName returns the chart of a release as Helm lists it, e.g.
+// "wordpress-5.0.0" for chart "stable/wordpress" in version "5.0.0".
+func chartName(chart, version string) string {
+	name := path.Base(chart)
+	if version == "" {
+		return name
+	}
+
+	return name + "-" + version
+}
+
+// deployedChart returns the chart of the deployed revision in a release's
+// history, or of the latest revision if none is deployed.
+func deployedChart(revisions []ReleaseRevision) string {
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if strings.ToLower(revisions[i].Status) == "deployed" {
+			return revisions[i].Chart
+		}
+	}
+	if len(revisions) > 0 {
+		return revisions[len(revisions)-1].Chart
+	}
+
+	return ""
+}
+
+// orphans returns a drift of each release in the cluster of the Apps that has
+// mh provenance recorded but no App.
+func (a Apps) orphans() ([]*AppDrift, *[]interface{}, error) {
+	unconfigured, cmd, err := a.unconfiguredReleases()
+	if err != nil {
+		return nil, cmd, err
+	}
+
+	var orphans []*AppDrift
+	for _, release := range unconfigured {
+		orphans = append(orphans, &AppDrift{
+			App:           release.Name,
+			Context:       a[0].TargetContext,
+			Status:        DriftOrphan,
+			DeployedChart: release.Chart,
+		})
+	}
+
+	return orphans, nil, nil
+}
+
+// AppDrifts are the drifts of Apps and orphaned releases.
+type AppDrifts []*AppDrift
+
+// Drifted returns true if any App or orphan drifted.
+func (d AppDrifts) Drifted() bool {
+	for _, drift := range d {
+		if drift.Drifted() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrintTable prints the AppDrifts as a human readable table, followed by the
+// values diffs of drifted Apps.
+func (d AppDrifts) PrintTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tAPP\tSTATUS\tDEPLOYED CHART\tRENDERED CHART\tVALUES")
+	for _, drift := range d {
+		values := ""
+		if drift.ValuesDiff != "" {
+			values = "differ"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			drift.Context,
+			drift.App,
+			drift.Status,
+			drift.DeployedChart,
+			drift.RenderedChart,
+			values,
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, drift := range d {
+		if drift.ValuesDiff != "" {
+			fmt.Fprintf(w, "\napp %s in %s\n%s", drift.App, drift.Context, drift.ValuesDiff)
+		}
+	}
+
+	return nil
+}
+
+// PrintJSON prints the AppDrifts as a JSON document.
+func (d AppDrifts) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
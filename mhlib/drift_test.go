@@ -0,0 +1,58 @@
+package mhlib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppsDrift(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	config.Provenance = &ReleaseProvenance{MHVersion: "v1"}
+
+	drifts, results := apps.Drift(config, RunOptions{}, true)
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 2 || drifts[0].Status != DriftNotInstalled || !drifts.Drifted() {
+		t.Fatalf("Unexpected drifts of not installed apps: %+v", drifts)
+	}
+
+	// Applied apps are in sync
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	drifts, _ = apps.Drift(config, RunOptions{}, true)
+	if len(drifts) != 2 || drifts.Drifted() || drifts[1].DeployedChart != "baz-0.1.0" {
+		t.Fatalf("Unexpected drifts of applied apps: %+v", drifts)
+	}
+
+	// Manual upgrades and releases mh applied for removed apps drift
+	backend.Values["foo"] = []byte("chart: stable/foo\nimage:\n  tag: \"1.1\"\nversion: 0.1.0\n")
+	backend.Releases["baz"] = append(backend.Releases["baz"], ReleaseRevision{Revision: 2, Status: "DEPLOYED", Chart: "baz-0.2.0"})
+	backend.Releases["old"] = []ReleaseRevision{{Revision: 1, Status: "DEPLOYED", Chart: "old-1.0.0"}}
+	if err := (revisionProvenances{1: {MHVersion: "v1"}}).write(apps[0].Kube, "old", ""); err != nil {
+		t.Fatal(err)
+	}
+	backend.Releases["manual"] = []ReleaseRevision{{Revision: 1, Status: "DEPLOYED", Chart: "manual-1.0.0"}}
+	backend.Values["manual"] = []byte("replicas: 2\n")
+
+	drifts, results = apps.Drift(config, RunOptions{}, true)
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 3 || drifts[0].Status != DriftDrifted || drifts[1].Status != DriftDrifted || drifts[2].Status != DriftOrphan || drifts[2].App != "old" {
+		t.Fatalf("Unexpected drifts: %+v", drifts)
+	}
+	if !strings.Contains(drifts[0].ValuesDiff, "-  tag: \"1.1\"") || drifts[1].ValuesDiff != "" {
+		t.Fatalf("Unexpected values diffs: %+v", drifts)
+	}
+
+	// Without orphans, only apps are compared
+	drifts, _ = apps.Drift(config, RunOptions{}, false)
+	if len(drifts) != 2 {
+		t.Fatalf("Unexpected drifts without orphans: %+v", drifts)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/strvals"
+)
+
+// ValueSource is a source that contributed to a value of the mh configuration.
+type ValueSource struct {
+	// Source is "<file>:<line>", "self-render pass <n>" or "--set <value>".
+	Source string
+	Value  interface{}
+	// Removed is true if the source removed the value.
+	Removed bool
+}
+
+// Explanation is the final value of a key of the mh configuration and the
+// sources that contributed to it, by rising priority.
+type Explanation struct {
+	Key     string
+	Value   interface{}
+	Sources []ValueSource
+}
+
+// Explanations are the Explanations of all values below a key.
+type Explanations []Explanation
+
+// Explain returns where the values below a key come from, as the App sees
+// them when it is rendered. The key is a dot-separated path relative to the
+// App's Key, or to the root of the configuration if it starts with a dot. An
+// empty key explains all values of the App's Key.
+func (a *App) Explain(config *RenderedConfig, key string) (Explanations, error) {
+	path := splitKey(a.Key)
+	if strings.HasPrefix(key, ".") {
+		path = splitKey(key)
+	} else if key != "" {
+		path = append(path, splitKey(key)...)
+	}
+
+	values := config.Values()
+	for _, value := range a.MHConfig.SETValues {
+		if err := strvals.ParseInto(value, values); err != nil {
+			return nil, fmt.Errorf("Failed to parse values provided via --set : %v", err)
+		}
+	}
+
+	final, ok := lookupPath(map[string]interface{}(values), path)
+	if !ok {
+		return nil, fmt.Errorf("Key %s not found in mh configuration", "."+strings.Join(path, "."))
+	}
+
+	var explanations Explanations
+	for _, leaf := range leafPaths(final, path) {
+		value, _ := lookupPath(map[string]interface{}(values), leaf)
+		if _, ok := config.secretSources["."+strings.Join(leaf, ".")]; ok && !a.PrintSecrets {
+			value = "***"
+		}
+		explanation := Explanation{
+			Key:     "." + strings.Join(leaf, "."),
+			Value:   value,
+			Sources: a.valueSources(config, leaf),
+		}
+		if !a.PrintSecrets {
+			explanation.redact(config.Redactor)
+		}
+		explanations = append(explanations, explanation)
+	}
+
+	return explanations, nil
+}
+
+// redact masks the values of an Explanation that are secrets, whatever their
+// length.
+func (e *Explanation) redact(redactor *Redactor) {
+	e.Value, _ = redactor.redactValue(e.Value)
+	for i := range e.Sources {
+		e.Sources[i].Value, _ = redactor.redactValue(e.Sources[i].Value)
+		if strings.HasPrefix(e.Sources[i].Source, "--set ") {
+			e.Sources[i].Source = "--set " + redactor.redactSetValue(strings.TrimPrefix(e.Sources[i].Source, "--set "))
+		}
+	}
+}
+
+// valueSources returns the sources that contributed to the value at path: the
+// layers of the configuration setting it, the self-render passes changing it,
+// its decryption and the --set values setting it.
+func (a *App) valueSources(config *RenderedConfig, path []string) []ValueSource {
+	var sources []ValueSource
+
+	for _, layer := range config.Layers {
+		value, ok := lookupPath(layer.document, path)
+		if !ok {
+			continue
+		}
+		source := layer.File
+		if line := findLine(layer.Data, path); line > 0 {
+			source = fmt.Sprintf("%s:%d", layer.File, line)
+		}
+		sources = append(sources, ValueSource{Source: source, Value: value, Removed: value == nil})
+	}
+
+	var last interface{}
+	for i, pass := range config.Passes {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(pass), &values); err != nil {
+			break
+		}
+		value, _ := lookupPath(values, path)
+		if i > 0 && !reflect.DeepEqual(value, last) {
+			sources = append(sources, ValueSource{Source: fmt.Sprintf("self-render pass %d", i), Value: value})
+		}
+		last = value
+	}
+
+	if source, ok := config.secretSources["."+strings.Join(path, ".")]; ok {
+		sources = append(sources, ValueSource{Source: source, Value: "***"})
+	}
+
+	for _, setValue := range a.MHConfig.SETValues {
+		values := map[string]interface{}{}
+		if err := strvals.ParseInto(setValue, values); err != nil {
+			continue
+		}
+		if value, ok := lookupPath(values, path); ok {
+			sources = append(sources, ValueSource{Source: "--set " + setValue, Value: value})
+		}
+	}
+
+	return sources
+}
+
+// Print writes the Explanations as human readable text.
+func (e Explanations) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, explanation := range e {
+		fmt.Fprintf(tw, "%s = %s\n", explanation.Key, formatValue(explanation.Value))
+		for _, source := range explanation.Sources {
+			value := formatValue(source.Value)
+			if source.Removed {
+				value = "(removed)"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\n", source.Source, value)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// PrintJSON writes the Explanations as a JSON document.
+func (e Explanations) PrintJSON(w io.Writer) error {
+	type jsonSource struct {
+		Source  string      `json:"source"`
+		Value   interface{} `json:"value"`
+		Removed bool        `json:"removed,omitempty"`
+	}
+	type jsonExplanation struct {
+		Key     string       `json:"key"`
+		Value   interface{}  `json:"value"`
+		Sources []jsonSource `json:"sources"`
+	}
+
+	doc := []jsonExplanation{}
+	for _, explanation := range e {
+		je := jsonExplanation{Key: explanation.Key, Value: explanation.Value, Sources: []jsonSource{}}
+		for _, source := range explanation.Sources {
+			je.Sources = append(je.Sources, jsonSource(source))
+		}
+		doc = append(doc, je)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// formatValue formats a value of the mh configuration on a single line.
+func formatValue(value interface{}) string {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(out)
+}
+
+// splitKey splits a dot-separated key into its path, ignoring a leading dot.
+func splitKey(key string) []string {
+	key = strings.TrimPrefix(key, ".")
+	if key == "" {
+		return nil
+	}
+
+	return strings.Split(key, ".")
+}
+
+// lookupPath returns the value at a path of nested maps.
+func lookupPath(value interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// leafPaths returns the paths of all values below path that are not maps,
+// sorted. A value that is not a map is a leaf itself.
+func leafPaths(value interface{}, path []string) [][]string {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return [][]string{path}
+	}
+
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var leaves [][]string
+	for _, key := range keys {
+		child := append(append([]string{}, path...), key)
+		leaves = append(leaves, leafPaths(m[key], child)...)
+	}
+
+	return leaves
+}
@@ -0,0 +1,98 @@
+package mhlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAppExplain(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"base.yaml": `
+foo:
+  image:
+    tag: "1.0"
+  replicas: 1
+`,
+		"main.yaml": `
+include:
+- base.yaml
+apps:
+- name: foo
+appSources:
+- name: apps
+  kind: configPath
+  source: apps
+version: "2.0"
+foo:
+  # The image follows the version
+  image:
+    tag: '[[ .version ]]'
+`,
+		"apps/foo.yaml": testAppFile,
+	})
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "main.yaml")
+	config, err := RenderConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	mhConfigFile := MHConfigFile{
+		Apps:       AppConfigs{{Name: "foo"}},
+		AppSources: testMHConfigFile.AppSources,
+	}
+	mhConfig := DefaultMHConfig
+	mhConfig.SETValues = []string{"foo.replicas=3"}
+	apps, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), configFile, Selector{}, false, mhConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	explanations, err := (*apps)[0].Explain(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Explanations{
+		{
+			Key:   ".foo.image.tag",
+			Value: "2.0",
+			Sources: []ValueSource{
+				{Source: filepath.Join(dir, "base.yaml") + ":4", Value: "1.0"},
+				{Source: configFile + ":14", Value: "[[ .version ]]"},
+				{Source: "self-render pass 1", Value: "2.0"},
+			},
+		},
+		{
+			Key:   ".foo.replicas",
+			Value: int64(3),
+			Sources: []ValueSource{
+				{Source: filepath.Join(dir, "base.yaml") + ":5", Value: float64(1)},
+				{Source: "--set foo.replicas=3", Value: int64(3)},
+			},
+		},
+	}
+	if !reflect.DeepEqual(explanations, expected) {
+		var out bytes.Buffer
+		explanations.Print(&out)
+		t.Fatalf("\nActual:\n%s\nExpected: %+v\n", out.String(), expected)
+	}
+
+	explanations, err = (*apps)[0].Explain(config, ".version")
+	if err != nil || len(explanations) != 1 || explanations[0].Sources[0].Source != configFile+":10" {
+		t.Fatalf("Unexpected explanation of .version: %+v (%v)", explanations, err)
+	}
+
+	if _, err := (*apps)[0].Explain(config, "missing"); err == nil {
+		t.Fatal("Expected an error for a missing key")
+	}
+}
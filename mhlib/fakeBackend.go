@@ -0,0 +1,354 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeCall is a call to a FakeBackend.
+type FakeCall struct {
+	Method    string
+	Release   string
+	Namespace string
+	Cmd       []interface{}
+	Options   *UpgradeOptions
+}
+
+// FakeBackend is an in-memory ReleaseBackend for testing. It records all calls
+// and keeps a history of the releases it installed, along with their values
+// and a fake manifest containing them.
+type FakeBackend struct {
+	Calls    []FakeCall
+	Releases map[string][]ReleaseRevision
+	Values   map[string][]byte
+	// Manifests replaces the fake manifests of releases.
+	Manifests map[string]string
+	// Errors makes calls fail, keyed by method and release, e.g.
+	// "Upgrade myapp".
+	Errors map[string]error
+
+	revisionValues map[string][][]byte
+	mutex          sync.Mutex
+}
+
+// NewFakeBackend returns an empty FakeBackend.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		Releases:  map[string][]ReleaseRevision{},
+		Values:    map[string][]byte{},
+		Manifests: map[string]string{},
+		Errors:    map[string]error{},
+
+		revisionValues: map[string][][]byte{},
+	}
+}
+
+// record adds a call and returns the error configured for it, if any.
+func (b *FakeBackend) record(call FakeCall) (*[]interface{}, error) {
+	b.Calls = append(b.Calls, call)
+
+	return &call.Cmd, b.Errors[call.Method+" "+call.Release]
+}
+
+// Upgrade records an upgrade and adds a revision unless it is a dry run.
+func (b *FakeBackend) Upgrade(options UpgradeOptions) (*[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "Upgrade",
+		Release:   options.Release,
+		Namespace: options.Namespace,
+		Cmd:       []interface{}{"upgrade", options.Release, options.Chart},
+		Options:   &options,
+	})
+	if err != nil {
+		return cmd, err
+	}
+
+	revisions := b.Releases[options.Release]
+	if len(revisions) == 0 && !options.Install {
+		return cmd, ErrReleaseNotFound
+	}
+
+	if !options.DryRun {
+		b.Releases[options.Release] = append(revisions, ReleaseRevision{
+			Revision: len(revisions) + 1,
+			Status:   "DEPLOYED",
+			Chart:    chartName(options.Chart, options.Version),
+		})
+		b.Values[options.Release] = options.Values
+		b.revisionValues[options.Release] = append(b.revisionValues[options.Release], options.Values)
+	}
+
+	return cmd, nil
+}
+
+// Delete records a delete and forgets the release if purged.
+func (b *FakeBackend) Delete(release, namespace string, purge bool) (*[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "Delete",
+		Release:   release,
+		Namespace: namespace,
+		Cmd:       []interface{}{"delete", release},
+	})
+	if err != nil {
+		return cmd, err
+	}
+
+	revisions, ok := b.Releases[release]
+	if !ok {
+		return cmd, ErrReleaseNotFound
+	}
+
+	if purge {
+		delete(b.Releases, release)
+		delete(b.Values, release)
+		delete(b.revisionValues, release)
+	} else {
+		revisions[len(revisions)-1].Status = "DELETED"
+	}
+
+	return cmd, nil
+}
+
+// Status records a status call.
+func (b *FakeBackend) Status(release, namespace string) (*[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "Status",
+		Release:   release,
+		Namespace: namespace,
+		Cmd:       []interface{}{"status", release},
+	})
+	if err != nil {
+		return cmd, err
+	}
+
+	if _, ok := b.Releases[release]; !ok {
+		return cmd, ErrReleaseNotFound
+	}
+
+	return cmd, nil
+}
+
+// DependencyUpdate records a dependency update.
+func (b *FakeBackend) DependencyUpdate(chart string) (*[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.record(FakeCall{
+		Method:  "DependencyUpdate",
+		Release: chart,
+		Cmd:     []interface{}{"dependency", "update"},
+	})
+}
+
+// History returns the recorded revisions of a release.
+func (b *FakeBackend) History(release, namespace string) ([]ReleaseRevision, *[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "History",
+		Release:   release,
+		Namespace: namespace,
+		Cmd:       []interface{}{"history", release},
+	})
+	if err != nil {
+		return nil, cmd, err
+	}
+
+	revisions, ok := b.Releases[release]
+	if !ok {
+		return nil, cmd, ErrReleaseNotFound
+	}
+
+	return append([]ReleaseRevision{}, revisions...), cmd, nil
+}
+
+// Rollback adds a revision copying the chart of the given revision.
+func (b *FakeBackend) Rollback(release, namespace string, revision int) (*[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "Rollback",
+		Release:   release,
+		Namespace: namespace,
+		Cmd:       []interface{}{"rollback", release, strconv.Itoa(revision)},
+	})
+	if err != nil {
+		return cmd, err
+	}
+
+	revisions := b.Releases[release]
+	if revision < 1 || revision > len(revisions) {
+		return cmd, fmt.Errorf("Release %s has no revision %d", release, revision)
+	}
+
+	b.Releases[release] = append(revisions, ReleaseRevision{
+		Revision:    len(revisions) + 1,
+		Status:      "DEPLOYED",
+		Chart:       revisions[revision-1].Chart,
+		Description: fmt.Sprintf("Rollback to %d", revision),
+	})
+	if values := b.revisionValues[release]; revision <= len(values) {
+		b.Values[release] = values[revision-1]
+		b.revisionValues[release] = append(values, values[revision-1])
+	}
+
+	return cmd, nil
+}
+
+// GetManifest returns the fake manifest of a release.
+func (b *FakeBackend) GetManifest(release, namespace string) (string, *[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "GetManifest",
+		Release:   release,
+		Namespace: namespace,
+		Cmd:       []interface{}{"get", "manifest", release},
+	})
+	if err != nil {
+		return "", cmd, err
+	}
+
+	values, ok := b.Values[release]
+	if !ok {
+		return "", cmd, ErrReleaseNotFound
+	}
+	if manifest, ok := b.Manifests[release]; ok {
+		return manifest, cmd, nil
+	}
+
+	return fakeManifest(release, values), cmd, nil
+}
+
+// GetValues returns the values of a revision of a release, or its latest
+// values.
+func (b *FakeBackend) GetValues(release, namespace string, revision int) ([]byte, *[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "GetValues",
+		Release:   release,
+		Namespace: namespace,
+		Cmd:       withRevision([]interface{}{"get", "values", release}, revision),
+	})
+	if err != nil {
+		return nil, cmd, err
+	}
+
+	values, ok := b.Values[release]
+	if !ok {
+		return nil, cmd, ErrReleaseNotFound
+	}
+	if revision != 0 {
+		if revision > len(b.revisionValues[release]) {
+			return nil, cmd, fmt.Errorf("Release %s has no revision %d", release, revision)
+		}
+		values = b.revisionValues[release][revision-1]
+	}
+
+	return values, cmd, nil
+}
+
+// List returns the releases that were not deleted, ordered by name.
+func (b *FakeBackend) List() ([]Release, *[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method: "List",
+		Cmd:    []interface{}{"list"},
+	})
+	if err != nil {
+		return nil, cmd, err
+	}
+
+	var names []string
+	for name := range b.Releases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var releases []Release
+	for _, name := range names {
+		revisions := b.Releases[name]
+		if len(revisions) == 0 || revisions[len(revisions)-1].Status == "DELETED" {
+			continue
+		}
+		latest := revisions[len(revisions)-1]
+		releases = append(releases, Release{Name: name, Status: latest.Status, Chart: latest.Chart})
+	}
+
+	return releases, cmd, nil
+}
+
+// RenderManifest returns the fake manifest an upgrade would deploy.
+func (b *FakeBackend) RenderManifest(options UpgradeOptions) (string, *[]interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cmd, err := b.record(FakeCall{
+		Method:    "RenderManifest",
+		Release:   options.Release,
+		Namespace: options.Namespace,
+		Cmd:       []interface{}{"upgrade", options.Release, options.Chart, "--dry-run"},
+		Options:   &options,
+	})
+	if err != nil {
+		return "", cmd, err
+	}
+
+	return fakeManifest(options.Release, options.Values), cmd, nil
+}
+
+// fakeManifest returns a manifest of a single ConfigMap containing values.
+func fakeManifest(release string, values []byte) string {
+	manifest := fmt.Sprintf("---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\ndata:\n  values: |\n", release)
+	for _, line := range strings.Split(strings.TrimSpace(string(values)), "\n") {
+		manifest += "    " + line + "\n"
+	}
+
+	return manifest
+}
+
+// Methods returns the methods of all recorded calls, e.g. "Upgrade myapp".
+func (b *FakeBackend) Methods() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var methods []string
+	for _, call := range b.Calls {
+		methods = append(methods, call.Method+" "+call.Release)
+	}
+
+	return methods
+}
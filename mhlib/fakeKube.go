@@ -0,0 +1,103 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"sync"
+
+	"github.com/ghodss/yaml"
+)
+
+// FakeKubeClient is an in-memory KubeClient for testing. Objects can change
+// their state each time they are read, like during a rollout.
+type FakeKubeClient struct {
+	// Objects are the successive states of objects as YAML, keyed by
+	// KubeObject.String(). Once all states were read, the last one stays.
+	Objects map[string][]string
+	// Reads counts how often each object was read.
+	Reads map[string]int
+
+	mutex sync.Mutex
+}
+
+// NewFakeKubeClient returns a FakeKubeClient without objects.
+func NewFakeKubeClient() *FakeKubeClient {
+	return &FakeKubeClient{
+		Objects: map[string][]string{},
+		Reads:   map[string]int{},
+	}
+}
+
+// Get returns the next state of each object that exists.
+func (c *FakeKubeClient) Get(objects []KubeObject) ([][]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var items [][]byte
+	for _, object := range objects {
+		states, ok := c.Objects[object.String()]
+		if !ok {
+			continue
+		}
+
+		i := c.Reads[object.String()]
+		if i >= len(states) {
+			i = len(states) - 1
+		}
+		c.Reads[object.String()]++
+
+		item, err := yaml.YAMLToJSON([]byte(states[i]))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Apply replaces all states of an object by the given one.
+func (c *FakeKubeClient) Apply(object []byte) error {
+	var metadata struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(object, &metadata); err != nil {
+		return err
+	}
+	out, err := yaml.JSONToYAML(object)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := KubeObject{Kind: metadata.Kind, Namespace: metadata.Metadata.Namespace, Name: metadata.Metadata.Name}.String()
+	c.Objects[key] = []string{string(out)}
+	return nil
+}
+
+// Delete forgets all states of an object.
+func (c *FakeKubeClient) Delete(object KubeObject) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.Objects, object.String())
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/codeskyblue/go-sh"
+)
+
+// CacheDir returns the directory mh caches data in. It is "mh" below
+// $XDG_CACHE_HOME, falling back to ~/.cache/mh.
+func CacheDir() (string, error) {
+	if cacheHome := os.Getenv("XDG_CACHE_HOME"); cacheHome != "" {
+		return filepath.Join(cacheHome, "mh"), nil
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("Neither XDG_CACHE_HOME nor HOME is set")
+	}
+
+	return filepath.Join(home, ".cache", "mh"), nil
+}
+
+// gitMutex serializes git checkouts, as checkouts of the same source and ref
+// share a clone.
+var gitMutex sync.Mutex
+
+// gitCheckout clones a git repository into the cache directory, or fetches it
+// if it was cloned before, and checks out ref. Without a ref the remote's
+// default branch is checked out. Returns the directory of the working tree.
+func gitCheckout(source, ref string) (string, error) {
+	cacheDir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+
+	// Each source and ref gets its own clone, named after a hash of both, so
+	// checking out one ref does not change the files of another.
+	dir := filepath.Join(cacheDir, "git", fmt.Sprintf("%x", sha256.Sum256([]byte(source+"\x00"+ref)))[:16])
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return "", fmt.Errorf("Failed to create cache directory: %v", err)
+		}
+		if _, err := gitOutput(filepath.Dir(dir), "clone", "--no-checkout", source, dir); err != nil {
+			return "", fmt.Errorf("Failed to clone %s: %v", source, err)
+		}
+	} else {
+		if _, err := gitOutput(dir, "fetch", "--force", "--tags", "--prune", "origin"); err != nil {
+			return "", fmt.Errorf("Failed to fetch %s: %v", source, err)
+		}
+	}
+
+	// Prefer remote branches over local ones, which may be stale. Tags and
+	// commits resolve either way.
+	candidates := []string{"origin/" + ref, ref}
+	if ref == "" {
+		candidates = []string{"origin/HEAD"}
+	}
+
+	var commit []byte
+	for _, candidate := range candidates {
+		commit, err = gitOutput(dir, "rev-parse", "--verify", "--quiet", candidate+"^{commit}")
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve ref %q of %s", ref, source)
+	}
+
+	if _, err := gitOutput(dir, "checkout", "--force", "--detach", strings.TrimSpace(string(commit))); err != nil {
+		return "", fmt.Errorf("Failed to check out ref %q of %s: %v", ref, source, err)
+	}
+
+	return dir, nil
+}
+
+// gitOutput runs a git command at dir and returns its output. Errors contain
+// git's error output.
+func gitOutput(dir string, args ...interface{}) ([]byte, error) {
+	var stderr bytes.Buffer
+	session := sh.NewSession()
+	session.Stderr = &stderr
+	session.SetDir(dir)
+
+	out, err := session.Command("git", args...).Output()
+	if err != nil {
+		return out, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
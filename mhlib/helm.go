@@ -0,0 +1,286 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/codeskyblue/go-sh"
+)
+
+// helmRun runs Helm, reading input from stdin if given and writing its output
+// to out, or to stdout and stderr if out is nil. Like helmOutput, it returns
+// errors about releases that do not exist as ErrReleaseNotFound.
+func helmRun(out io.Writer, cmd []interface{}, input string) error {
+	var stderr bytes.Buffer
+	session := sh.NewSession()
+	session.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if out != nil {
+		session.Stdout = out
+		session.Stderr = io.MultiWriter(out, &stderr)
+	}
+	if input != "" {
+		session.SetInput(input)
+	}
+
+	err := session.Command("helm", cmd...).Run()
+	if err != nil && releaseNotFound.MatchString(stderr.String()) {
+		return ErrReleaseNotFound
+	}
+	return err
+}
+
+// helmDependencyUpdate runs `helm dependency update` at a chart's directory.
+// Its arguments are the same for all Helm versions.
+func helmDependencyUpdate(chart string) (*[]interface{}, error) {
+	cmd := []interface{}{"dependency", "update"}
+
+	// Start a new shell session here to avoid running `cd`.
+	session := sh.NewSession()
+	session.SetDir(chart)
+
+	out, err := session.Command("helm", cmd...).Output()
+	if err != nil {
+		return &cmd, fmt.Errorf("%v: %s", err, out)
+	}
+
+	return &cmd, nil
+}
+
+// helmHistory runs a `helm history` command with JSON output and parses it.
+func helmHistory(cmd []interface{}) ([]ReleaseRevision, *[]interface{}, error) {
+	out, err := helmOutput(cmd, "")
+	if err != nil {
+		return nil, &cmd, err
+	}
+
+	var revisions []ReleaseRevision
+	if err := json.Unmarshal(out, &revisions); err != nil {
+		return nil, &cmd, fmt.Errorf("Failed to parse release history: %v", err)
+	}
+
+	return revisions, &cmd, nil
+}
+
+// helmList runs a `helm list` command with JSON output and parses the releases
+// it lists as Helm 2 or Helm 3 does. Helm 2 lists releases in pages, so the
+// following pages are fetched with --offset.
+func helmList(cmd []interface{}) ([]Release, *[]interface{}, error) {
+	var releases []Release
+	offset := ""
+	for {
+		pageCmd := cmd
+		if offset != "" {
+			pageCmd = append(append([]interface{}{}, cmd...), "--offset", offset)
+		}
+		out, err := helmOutput(pageCmd, "")
+		if err != nil {
+			return nil, &pageCmd, err
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			return releases, &cmd, nil
+		}
+
+		var page []Release
+		if bytes.HasPrefix(bytes.TrimSpace(out), []byte("{")) {
+			var list struct {
+				Next     string    `json:"next"`
+				Releases []Release `json:"releases"`
+			}
+			err = json.Unmarshal(out, &list)
+			page, offset = list.Releases, list.Next
+		} else {
+			err = json.Unmarshal(out, &page)
+			offset = ""
+		}
+		if err != nil {
+			return nil, &pageCmd, fmt.Errorf("Failed to parse releases: %v", err)
+		}
+
+		releases = append(releases, page...)
+		if offset == "" {
+			return releases, &cmd, nil
+		}
+	}
+}
+
+// unknownOutputFlag matches the error of older Helm 2 versions, e.g. 2.9.1,
+// whose `helm history` and `helm list` can not print JSON.
+var unknownOutputFlag = regexp.MustCompile(`unknown flag: --output`)
+
+// helmHistoryTable runs a `helm history` command printing a table and parses
+// it.
+func helmHistoryTable(cmd []interface{}) ([]ReleaseRevision, *[]interface{}, error) {
+	out, err := helmOutput(cmd, "")
+	if err != nil {
+		return nil, &cmd, err
+	}
+
+	var revisions []ReleaseRevision
+	for _, row := range parseHelmTable(out) {
+		revision, err := strconv.Atoi(row["REVISION"])
+		if err != nil {
+			return nil, &cmd, fmt.Errorf("Failed to parse release history: %v", err)
+		}
+		revisions = append(revisions, ReleaseRevision{
+			Revision:    revision,
+			Updated:     row["UPDATED"],
+			Status:      row["STATUS"],
+			Chart:       row["CHART"],
+			AppVersion:  row["APP VERSION"],
+			Description: row["DESCRIPTION"],
+		})
+	}
+
+	return revisions, &cmd, nil
+}
+
+// helmListTable runs a `helm list` command printing a table and parses it.
+// Following pages are fetched with --offset like helmList does.
+func helmListTable(cmd []interface{}) ([]Release, *[]interface{}, error) {
+	var releases []Release
+	offset := ""
+	for {
+		pageCmd := cmd
+		if offset != "" {
+			pageCmd = append(append([]interface{}{}, cmd...), "--offset", offset)
+		}
+		out, err := helmOutput(pageCmd, "")
+		if err != nil {
+			return nil, &pageCmd, err
+		}
+
+		for _, row := range parseHelmTable(out) {
+			releases = append(releases, Release{
+				Name:      row["NAME"],
+				Namespace: row["NAMESPACE"],
+				Status:    row["STATUS"],
+				Chart:     row["CHART"],
+			})
+		}
+
+		// More pages are announced as "next: <release>" after the table
+		offset = ""
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, "next: ") {
+				offset = strings.TrimPrefix(line, "next: ")
+			}
+		}
+		if offset == "" {
+			return releases, &cmd, nil
+		}
+	}
+}
+
+// parseHelmTable parses a table printed by Helm into a row per line, keyed by
+// the headers on its first line. Columns are separated by tabs and padded
+// with spaces. Lines with another number of columns are ignored.
+func parseHelmTable(out []byte) []map[string]string {
+	var (
+		headers []string
+		rows    []map[string]string
+	)
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		columns := strings.Split(line, "\t")
+		if headers == nil {
+			for _, column := range columns {
+				headers = append(headers, strings.TrimSpace(column))
+			}
+			continue
+		}
+		if len(columns) != len(headers) {
+			continue
+		}
+
+		row := map[string]string{}
+		for i, column := range columns {
+			row[headers[i]] = strings.TrimSpace(column)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// withRevision appends the revision flag to a Helm command if a revision is
+// given. Otherwise Helm uses the deployed revision.
+func withRevision(cmd []interface{}, revision int) []interface{} {
+	if revision != 0 {
+		cmd = append(cmd, "--revision", strconv.Itoa(revision))
+	}
+
+	return cmd
+}
+
+// releaseNotFound matches the errors of Helm 2 (`release: "foo" not found`)
+// and Helm 3 (`release: not found`) about releases that do not exist.
+var releaseNotFound = regexp.MustCompile(`release: ("[^"]*" )?not found`)
+
+// helmOutput runs a Helm command with the given input and returns its output.
+// Errors contain Helm's error output. Errors about releases that do not exist
+// are returned as ErrReleaseNotFound, other things not being found, like kube
+// contexts or charts, are not.
+func helmOutput(cmd []interface{}, input string) ([]byte, error) {
+	var stderr bytes.Buffer
+	session := sh.NewSession()
+	session.Stderr = &stderr
+	if input != "" {
+		session.SetInput(input)
+	}
+
+	out, err := session.Command("helm", cmd...).Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if releaseNotFound.MatchString(message) {
+			return out, ErrReleaseNotFound
+		}
+		return out, fmt.Errorf("%v: %s", err, message)
+	}
+
+	return out, nil
+}
+
+// extractManifest returns the manifest section of `helm upgrade --dry-run`
+// output. It starts after the "MANIFEST:" line and ends at the next section
+// header like "NOTES:" or at the closing "Release ..." message.
+func extractManifest(out string) string {
+	header := regexp.MustCompile(`^([A-Z][A-Z -]*:|Release ")`)
+
+	var manifest []string
+	inManifest := false
+	for _, line := range strings.Split(out, "\n") {
+		if inManifest && header.MatchString(line) {
+			break
+		}
+		if inManifest {
+			manifest = append(manifest, line)
+		}
+		if line == "MANIFEST:" {
+			inManifest = true
+		}
+	}
+
+	return strings.Join(manifest, "\n")
+}
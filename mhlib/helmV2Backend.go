@@ -0,0 +1,164 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"io"
+	"strconv"
+)
+
+// HelmV2Backend is a ReleaseBackend running the Helm v2 CLI.
+type HelmV2Backend struct {
+	kube KubeOptions
+	out  io.Writer // Where Helm's output goes, stdout and stderr if nil.
+}
+
+// setOutput sends the output of Helm commands to out.
+func (b *HelmV2Backend) setOutput(out io.Writer) {
+	b.out = out
+}
+
+// Upgrade runs `helm upgrade`, reading values from stdin.
+func (b *HelmV2Backend) Upgrade(options UpgradeOptions) (*[]interface{}, error) {
+	cmd := b.upgradeCmd(options)
+
+	err := helmRun(b.out, cmd, string(options.Values))
+	return &cmd, err
+}
+
+// RenderManifest runs `helm upgrade` in dry-run mode and returns the manifest
+// from its debug output.
+func (b *HelmV2Backend) RenderManifest(options UpgradeOptions) (string, *[]interface{}, error) {
+	options.DryRun = true
+	cmd := b.upgradeCmd(options)
+
+	out, err := helmOutput(cmd, string(options.Values))
+	if err != nil {
+		return "", &cmd, err
+	}
+
+	return extractManifest(string(out)), &cmd, nil
+}
+
+// upgradeCmd returns the arguments to `helm upgrade` for UpgradeOptions.
+func (b *HelmV2Backend) upgradeCmd(options UpgradeOptions) []interface{} {
+	cmd := []interface{}{"upgrade", options.Release, options.Chart}
+
+	// "specify the exact chart version to install. If this is not specified, the latest version is installed"
+	if options.Version != "" {
+		cmd = append(cmd, "--version", options.Version)
+	}
+
+	if options.DryRun {
+		// "enable verbose output"
+		cmd = append(cmd, "--debug")
+
+		// "simulate an upgrade"
+		cmd = append(cmd, "--dry-run")
+	}
+
+	if options.Force {
+		// "force resource update through delete/recreate if needed"
+		cmd = append(cmd, "--force")
+	}
+
+	if options.Install {
+		// "if a release by this name doesn't already exist, run an install"
+		cmd = append(cmd, "--install")
+	}
+
+	if options.RecreatePods {
+		// "performs pods restart for the resource if applicable"
+		cmd = append(cmd, "--recreate-pods")
+	}
+
+	// "namespace to install the release into. (Defaults to helm default behaviour => kubeconfig checked out ns)"
+	if options.Namespace != "" {
+		cmd = append(cmd, "--namespace", options.Namespace)
+	}
+
+	// Make `helm upgrade` read overrides from stdin
+	return b.kube.helmFlags(append(cmd, "--values", "-"))
+}
+
+// Delete runs `helm delete`.
+func (b *HelmV2Backend) Delete(release, namespace string, purge bool) (*[]interface{}, error) {
+	cmd := b.kube.helmFlags([]interface{}{"delete", release})
+	if purge {
+		cmd = append(cmd, "--purge")
+	}
+
+	err := helmRun(b.out, cmd, "")
+	return &cmd, err
+}
+
+// Status runs `helm status`.
+func (b *HelmV2Backend) Status(release, namespace string) (*[]interface{}, error) {
+	cmd := b.kube.helmFlags([]interface{}{"status", release})
+
+	err := helmRun(b.out, cmd, "")
+	return &cmd, err
+}
+
+// DependencyUpdate runs `helm dependency update` at the chart's directory.
+func (b *HelmV2Backend) DependencyUpdate(chart string) (*[]interface{}, error) {
+	return helmDependencyUpdate(chart)
+}
+
+// History runs `helm history` and parses its JSON output, or its table with
+// Helm versions that can not print JSON.
+func (b *HelmV2Backend) History(release, namespace string) ([]ReleaseRevision, *[]interface{}, error) {
+	revisions, cmd, err := helmHistory(b.kube.helmFlags([]interface{}{"history", release, "--output", "json"}))
+	if err != nil && unknownOutputFlag.MatchString(err.Error()) {
+		return helmHistoryTable(b.kube.helmFlags([]interface{}{"history", release}))
+	}
+
+	return revisions, cmd, err
+}
+
+// List runs `helm list` and parses its JSON output, or its table with Helm
+// versions that can not print JSON.
+func (b *HelmV2Backend) List() ([]Release, *[]interface{}, error) {
+	releases, cmd, err := helmList(b.kube.helmFlags([]interface{}{"list", "--output", "json"}))
+	if err != nil && unknownOutputFlag.MatchString(err.Error()) {
+		return helmListTable(b.kube.helmFlags([]interface{}{"list"}))
+	}
+
+	return releases, cmd, err
+}
+
+// GetManifest runs `helm get manifest`.
+func (b *HelmV2Backend) GetManifest(release, namespace string) (string, *[]interface{}, error) {
+	cmd := b.kube.helmFlags([]interface{}{"get", "manifest", release})
+
+	out, err := helmOutput(cmd, "")
+	return string(out), &cmd, err
+}
+
+// GetValues runs `helm get values`.
+func (b *HelmV2Backend) GetValues(release, namespace string, revision int) ([]byte, *[]interface{}, error) {
+	cmd := b.kube.helmFlags(withRevision([]interface{}{"get", "values", release}, revision))
+
+	out, err := helmOutput(cmd, "")
+	return out, &cmd, err
+}
+
+// Rollback runs `helm rollback`.
+func (b *HelmV2Backend) Rollback(release, namespace string, revision int) (*[]interface{}, error) {
+	cmd := b.kube.helmFlags([]interface{}{"rollback", release, strconv.Itoa(revision)})
+
+	err := helmRun(b.out, cmd, "")
+	return &cmd, err
+}
@@ -0,0 +1,172 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HelmV3Backend is a ReleaseBackend running the Helm v3 CLI. Releases are
+// identified by name and namespace and there is no Tiller.
+type HelmV3Backend struct {
+	log  *logrus.Entry
+	kube KubeOptions
+	out  io.Writer // Where Helm's output goes, stdout and stderr if nil.
+}
+
+// setOutput sends the output of Helm commands to out.
+func (b *HelmV3Backend) setOutput(out io.Writer) {
+	b.out = out
+}
+
+// Upgrade runs `helm upgrade`, reading values from stdin. Options Helm 3 does
+// not support are ignored with a warning.
+func (b *HelmV3Backend) Upgrade(options UpgradeOptions) (*[]interface{}, error) {
+	cmd := b.upgradeCmd(options)
+
+	err := helmRun(b.out, cmd, string(options.Values))
+	return &cmd, err
+}
+
+// RenderManifest runs `helm upgrade` in dry-run mode and returns the manifest
+// from its output.
+func (b *HelmV3Backend) RenderManifest(options UpgradeOptions) (string, *[]interface{}, error) {
+	options.DryRun = true
+	cmd := b.upgradeCmd(options)
+
+	out, err := helmOutput(cmd, string(options.Values))
+	if err != nil {
+		return "", &cmd, err
+	}
+
+	return extractManifest(string(out)), &cmd, nil
+}
+
+// upgradeCmd returns the arguments to `helm upgrade` for UpgradeOptions.
+func (b *HelmV3Backend) upgradeCmd(options UpgradeOptions) []interface{} {
+	cmd := []interface{}{"upgrade", options.Release, options.Chart}
+
+	// "specify the exact chart version to use. If this is not specified, the latest version is used"
+	if options.Version != "" {
+		cmd = append(cmd, "--version", options.Version)
+	}
+
+	if options.DryRun {
+		// "enable verbose output"
+		cmd = append(cmd, "--debug")
+
+		// "simulate an upgrade"
+		cmd = append(cmd, "--dry-run")
+	}
+
+	if options.Force {
+		// "force resource updates through a replacement strategy"
+		cmd = append(cmd, "--force")
+	}
+
+	if options.Install {
+		// "if a release by this name doesn't already exist, run an install"
+		cmd = append(cmd, "--install")
+	}
+
+	if options.RecreatePods {
+		b.log.Warn("Helm 3 does not support recreating pods, ignoring it")
+	}
+
+	// "namespace scope for this request"
+	if options.Namespace != "" {
+		cmd = append(cmd, "--namespace", options.Namespace)
+
+		// "create the release namespace if not present"
+		if options.Install {
+			cmd = append(cmd, "--create-namespace")
+		}
+	}
+
+	// Make `helm upgrade` read overrides from stdin
+	return b.kube.helmFlags(append(cmd, "--values", "-"))
+}
+
+// Delete runs `helm uninstall`. Without purge the release history is kept.
+func (b *HelmV3Backend) Delete(release, namespace string, purge bool) (*[]interface{}, error) {
+	cmd := b.kube.helmFlags(withNamespace([]interface{}{"uninstall", release}, namespace))
+	if !purge {
+		cmd = append(cmd, "--keep-history")
+	}
+
+	err := helmRun(b.out, cmd, "")
+	return &cmd, err
+}
+
+// Status runs `helm status`.
+func (b *HelmV3Backend) Status(release, namespace string) (*[]interface{}, error) {
+	cmd := b.kube.helmFlags(withNamespace([]interface{}{"status", release}, namespace))
+
+	err := helmRun(b.out, cmd, "")
+	return &cmd, err
+}
+
+// DependencyUpdate runs `helm dependency update` at the chart's directory.
+func (b *HelmV3Backend) DependencyUpdate(chart string) (*[]interface{}, error) {
+	return helmDependencyUpdate(chart)
+}
+
+// History runs `helm history` and parses its JSON output.
+func (b *HelmV3Backend) History(release, namespace string) ([]ReleaseRevision, *[]interface{}, error) {
+	return helmHistory(b.kube.helmFlags(withNamespace([]interface{}{"history", release, "--output", "json"}, namespace)))
+}
+
+// List runs `helm list` in all namespaces, without its default limit of 256
+// releases, and parses its JSON output.
+func (b *HelmV3Backend) List() ([]Release, *[]interface{}, error) {
+	return helmList(b.kube.helmFlags([]interface{}{"list", "--all-namespaces", "--max", "0", "--output", "json"}))
+}
+
+// GetManifest runs `helm get manifest`.
+func (b *HelmV3Backend) GetManifest(release, namespace string) (string, *[]interface{}, error) {
+	cmd := b.kube.helmFlags(withNamespace([]interface{}{"get", "manifest", release}, namespace))
+
+	out, err := helmOutput(cmd, "")
+	return string(out), &cmd, err
+}
+
+// GetValues runs `helm get values` with YAML output.
+func (b *HelmV3Backend) GetValues(release, namespace string, revision int) ([]byte, *[]interface{}, error) {
+	cmd := b.kube.helmFlags(withNamespace(withRevision([]interface{}{"get", "values", release, "--output", "yaml"}, revision), namespace))
+
+	out, err := helmOutput(cmd, "")
+	return out, &cmd, err
+}
+
+// Rollback runs `helm rollback`.
+func (b *HelmV3Backend) Rollback(release, namespace string, revision int) (*[]interface{}, error) {
+	cmd := b.kube.helmFlags(withNamespace([]interface{}{"rollback", release, strconv.Itoa(revision)}, namespace))
+
+	err := helmRun(b.out, cmd, "")
+	return &cmd, err
+}
+
+// withNamespace appends the namespace flag to a Helm 3 command if a namespace
+// is given. Otherwise Helm uses the kubeconfig's namespace.
+func withNamespace(cmd []interface{}, namespace string) []interface{} {
+	if namespace != "" {
+		cmd = append(cmd, "--namespace", namespace)
+	}
+
+	return cmd
+}
@@ -0,0 +1,246 @@
+package mhlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeHelm puts a script acting as helm first in the PATH and returns a
+// function restoring the PATH.
+func fakeHelm(t *testing.T, script string) func() {
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "helm"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHelmOutputReleaseNotFound(t *testing.T) {
+	// Fake helm failing like Helm 2 and 3 for missing releases and kube contexts
+	helm := `#!/bin/sh
+case "$*" in
+  "get values v2") echo 'Error: release: "v2" not found' >&2 ;;
+  "get values v3") echo 'Error: release: not found' >&2 ;;
+  *) echo 'Error: kube context "prod" not found' >&2 ;;
+esac
+exit 1
+`
+	defer fakeHelm(t, helm)()
+
+	for _, release := range []string{"v2", "v3"} {
+		if _, err := helmOutput([]interface{}{"get", "values", release}, ""); err != ErrReleaseNotFound {
+			t.Fatalf("Missing %s release returned %v", release, err)
+		}
+	}
+
+	// Other things not found are reported as they are
+	_, err := helmOutput([]interface{}{"get", "values", "foo", "--kube-context", "prod"}, "")
+	if err == ErrReleaseNotFound || err == nil || !strings.Contains(err.Error(), `kube context "prod" not found`) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestHelmListPages(t *testing.T) {
+	// Fake helm listing releases in pages like Helm 2 does
+	helm := `#!/bin/sh
+case "$*" in
+  "list --output json") echo '{"Next":"bar","Releases":[{"Name":"foo","Namespace":"default"}]}' ;;
+  "list --output json --offset bar") echo '{"Releases":[{"Name":"bar","Namespace":"default"}]}' ;;
+  "list --all-namespaces --max 0 --output json") echo '[{"name":"baz","namespace":"kube-system"}]' ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	defer fakeHelm(t, helm)()
+
+	releases, _, err := (&HelmV2Backend{}).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[0].Name != "foo" || releases[1].Name != "bar" {
+		t.Fatalf("Unexpected Helm 2 releases: %+v", releases)
+	}
+
+	releases, _, err = (&HelmV3Backend{}).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 || releases[0].Name != "baz" || releases[0].Namespace != "kube-system" {
+		t.Fatalf("Unexpected Helm 3 releases: %+v", releases)
+	}
+}
+
+func TestHelmDeleteReleaseNotFound(t *testing.T) {
+	// Fake helm deleting foo and failing like Helm 2 and 3 for other releases
+	helm := `#!/bin/sh
+case "$*" in
+  "delete foo --purge"|"uninstall foo --namespace apps") echo "release \"foo\" deleted" ;;
+  "delete bar --purge") echo 'Error: release: "bar" not found' >&2; exit 1 ;;
+  "uninstall bar --namespace apps") echo 'Error: uninstall: Release not loaded: bar: release: not found' >&2; exit 1 ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	defer fakeHelm(t, helm)()
+
+	var out bytes.Buffer
+	for _, backend := range []ReleaseBackend{&HelmV2Backend{out: &out}, &HelmV3Backend{out: &out}} {
+		if _, err := backend.Delete("foo", "apps", true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.Delete("bar", "apps", true); err != ErrReleaseNotFound {
+			t.Fatalf("Missing release returned %v", err)
+		}
+	}
+
+	// Helm's output goes to the backend's output
+	if !strings.Contains(out.String(), `release "foo" deleted`) {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestHelmHistoryRollback(t *testing.T) {
+	// Fake helm answering like Helm 2 and 3 do
+	helm := `#!/bin/sh
+case "$*" in
+  "history foo --output json") echo '[{"revision":1,"updated":"Mon Oct  1 10:00:00 2018","status":"SUPERSEDED","chart":"foo-0.1.0","description":"Install complete"},{"revision":2,"updated":"Tue Oct  2 10:00:00 2018","status":"DEPLOYED","chart":"foo-0.2.0","description":"Upgrade complete"}]' ;;
+  "history foo --output json --namespace apps") echo '[{"revision":1,"updated":"2020-10-01T10:00:00Z","status":"superseded","chart":"foo-0.1.0","app_version":"1.0","description":"Install complete"},{"revision":2,"updated":"2020-10-02T10:00:00Z","status":"deployed","chart":"foo-0.2.0","app_version":"2.0","description":"Upgrade complete"}]' ;;
+  "history bar --output json") echo 'Error: release: "bar" not found' >&2; exit 1 ;;
+  "history bar --output json --namespace apps") echo 'Error: release: not found' >&2; exit 1 ;;
+  "rollback foo 1"|"rollback foo 1 --namespace apps") echo 'Rollback was a success.' ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	defer fakeHelm(t, helm)()
+
+	var out bytes.Buffer
+	for _, backend := range []ReleaseBackend{&HelmV2Backend{out: &out}, &HelmV3Backend{out: &out}} {
+		revisions, _, err := backend.History("foo", "apps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 2 || revisions[0].Revision != 1 || revisions[1].Chart != "foo-0.2.0" ||
+			!strings.EqualFold(revisions[1].Status, "deployed") || revisions[1].Description != "Upgrade complete" {
+			t.Fatalf("Unexpected revisions: %+v", revisions)
+		}
+		if _, _, err := backend.History("bar", "apps"); err != ErrReleaseNotFound {
+			t.Fatalf("Missing release returned %v", err)
+		}
+
+		if _, err := backend.Rollback("foo", "apps", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out.String() != "Rollback was a success.\nRollback was a success.\n" {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestHelmGetValues(t *testing.T) {
+	// Fake helm printing the user-supplied values of revisions like Helm 2 and
+	// 3 do
+	helm := `#!/bin/sh
+case "$*" in
+  "get values foo"|"get values foo --output yaml --namespace apps") printf 'image:\n  tag: "2.0"\n' ;;
+  "get values foo --revision 1"|"get values foo --output yaml --revision 1 --namespace apps") printf 'image:\n  tag: "1.0"\n' ;;
+  "get values bar"|"get values bar --output yaml --namespace apps") echo 'Error: release: not found' >&2; exit 1 ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	defer fakeHelm(t, helm)()
+
+	for _, backend := range []ReleaseBackend{&HelmV2Backend{}, &HelmV3Backend{}} {
+		for revision, expected := range map[int]string{0: "2.0", 1: "1.0"} {
+			values, _, err := backend.GetValues("foo", "apps", revision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(values) != "image:\n  tag: \""+expected+"\"\n" {
+				t.Fatalf("Unexpected values of revision %d: %q", revision, values)
+			}
+		}
+		if _, _, err := backend.GetValues("bar", "apps", 0); err != ErrReleaseNotFound {
+			t.Fatalf("Missing release returned %v", err)
+		}
+	}
+}
+
+func TestHelmDeletePurge(t *testing.T) {
+	// Fake helm accepting deletes with and without purging like Helm 2 and 3
+	helm := `#!/bin/sh
+case "$*" in
+  "delete foo"|"uninstall foo --namespace apps --keep-history") echo 'kept' ;;
+  "delete foo --purge"|"uninstall foo --namespace apps") echo 'purged' ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	defer fakeHelm(t, helm)()
+
+	for _, backend := range []ReleaseBackend{&HelmV2Backend{}, &HelmV3Backend{}} {
+		var out bytes.Buffer
+		backend.(outputSetter).setOutput(&out)
+		for _, purge := range []bool{false, true} {
+			if _, err := backend.Delete("foo", "apps", purge); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if out.String() != "kept\npurged\n" {
+			t.Fatalf("Unexpected output: %q", out.String())
+		}
+	}
+}
+
+func TestHelmV2Tables(t *testing.T) {
+	// Fake helm printing tables like Helm 2.9 does, without --output
+	helm := `#!/bin/sh
+case "$*" in
+  *--output*) echo 'Error: unknown flag: --output' >&2; exit 1 ;;
+  "history foo") printf 'REVISION\tUPDATED                 \tSTATUS    \tCHART    \tDESCRIPTION     \n1       \tMon Oct  1 10:00:00 2018\tSUPERSEDED\tfoo-0.1.0\tInstall complete\n2       \tTue Oct  2 10:00:00 2018\tDEPLOYED  \tfoo-0.2.0\tUpgrade complete\n' ;;
+  "history bar") echo 'Error: release: "bar" not found' >&2; exit 1 ;;
+  "list") printf 'NAME\tREVISION\tUPDATED                 \tSTATUS  \tCHART    \tNAMESPACE\nfoo \t2       \tTue Oct  2 10:00:00 2018\tDEPLOYED\tfoo-0.2.0\tapps     \n\tnext: bar\n' ;;
+  "list --offset bar") printf 'NAME\tREVISION\tUPDATED                 \tSTATUS\tCHART    \tNAMESPACE\nbar \t1       \tMon Oct  1 10:00:00 2018\tFAILED\tbar-0.1.0\tdefault  \n' ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	defer fakeHelm(t, helm)()
+	backend := &HelmV2Backend{}
+
+	revisions, _, err := backend.History("foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ReleaseRevision{
+		{Revision: 1, Updated: "Mon Oct  1 10:00:00 2018", Status: "SUPERSEDED", Chart: "foo-0.1.0", Description: "Install complete"},
+		{Revision: 2, Updated: "Tue Oct  2 10:00:00 2018", Status: "DEPLOYED", Chart: "foo-0.2.0", Description: "Upgrade complete"},
+	}
+	if !reflect.DeepEqual(revisions, expected) {
+		t.Fatalf("\nActual: %+v\nExpected: %+v", revisions, expected)
+	}
+	if _, _, err := backend.History("bar", ""); err != ErrReleaseNotFound {
+		t.Fatalf("Missing release returned %v", err)
+	}
+
+	releases, _, err := backend.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(releases, []Release{
+		{Name: "foo", Namespace: "apps", Status: "DEPLOYED", Chart: "foo-0.2.0"},
+		{Name: "bar", Namespace: "default", Status: "FAILED", Chart: "bar-0.1.0"},
+	}) {
+		t.Fatalf("Unexpected releases: %+v", releases)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// AppRevision is a revision of an App's release along with the git SHA of the
+// mh configuration it was applied from, if recorded.
+type AppRevision struct {
+	ReleaseRevision
+	GitSHA string `json:"gitSHA,omitempty"`
+}
+
+// AppHistory is the history of an App's release in a context.
+type AppHistory struct {
+	App       string        `json:"app"`
+	Context   string        `json:"context"`
+	Revisions []AppRevision `json:"revisions"`
+}
+
+// History returns up to max of the latest revisions of the App's release,
+// oldest first. All revisions are returned if max is 0.
+func (a *App) History(max int) (*AppHistory, *[]interface{}, error) {
+	revisions, cmd, err := a.Backend.History(a.ID, a.Namespace)
+	if err != nil {
+		return nil, cmd, err
+	}
+	if max > 0 && len(revisions) > max {
+		revisions = revisions[len(revisions)-max:]
+	}
+
+	provenances, err := readProvenances(a.Kube, a.ID, a.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history := &AppHistory{App: a.ID, Context: a.TargetContext, Revisions: []AppRevision{}}
+	for _, revision := range revisions {
+		appRevision := AppRevision{ReleaseRevision: revision}
+		if provenance := provenances[revision.Revision]; provenance != nil {
+			appRevision.GitSHA = provenance.GitSHA
+		}
+		history.Revisions = append(history.Revisions, appRevision)
+	}
+
+	return history, nil, nil
+}
+
+// Rollback rolls the App's release back to a revision. If revision is 0, it
+// rolls back to the revision deployed before the latest one.
+func (a *App) Rollback(revision int) (*[]interface{}, error) {
+	revisions, cmd, err := a.Backend.History(a.ID, a.Namespace)
+	if err != nil {
+		return cmd, err
+	}
+
+	if revision == 0 {
+		for i := len(revisions) - 2; i >= 0; i-- {
+			status := strings.ToLower(revisions[i].Status)
+			if status == "superseded" || status == "deployed" {
+				revision = revisions[i].Revision
+				break
+			}
+		}
+		if revision == 0 {
+			return nil, fmt.Errorf("Release %s has no previous revision to roll back to", a.ID)
+		}
+	} else {
+		found := false
+		for _, r := range revisions {
+			found = found || r.Revision == revision
+		}
+		if !found {
+			return nil, fmt.Errorf("Release %s has no revision %d", a.ID, revision)
+		}
+	}
+
+	a.log.WithField("revision", revision).Info("Rolling back app")
+	cmd, err = a.Backend.Rollback(a.ID, a.Namespace, revision)
+	if err != nil {
+		return cmd, err
+	}
+
+	return cmd, a.recordRollbackProvenance(revision)
+}
+
+// AppHistories are the histories of Apps.
+type AppHistories []*AppHistory
+
+// PrintTable prints the revisions of all AppHistories as a human readable
+// table.
+func (h AppHistories) PrintTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tAPP\tREVISION\tUPDATED\tSTATUS\tCHART\tAPP VERSION\tGIT SHA\tDESCRIPTION")
+	for _, history := range h {
+		for _, revision := range history.Revisions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				history.Context,
+				history.App,
+				strconv.Itoa(revision.Revision),
+				revision.Updated,
+				revision.Status,
+				revision.Chart,
+				revision.AppVersion,
+				revision.GitSHA,
+				revision.Description,
+			)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// PrintJSON prints the AppHistories as a JSON document.
+func (h AppHistories) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h)
+}
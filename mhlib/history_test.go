@@ -0,0 +1,45 @@
+package mhlib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAppsHistoryAndRollback(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	foo := apps[:1]
+
+	for i := 0; i < 2; i++ {
+		if err := foo.Apply(config, RunOptions{}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	histories, results := foo.History(1, RunOptions{})
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 1 || histories[0].App != "foo" || len(histories[0].Revisions) != 1 || histories[0].Revisions[0].Revision != 2 {
+		t.Fatalf("Unexpected histories: %+v", histories)
+	}
+
+	// Without a revision, apps are rolled back to the previous one
+	if err := foo.Rollback(0, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if call := backend.Calls[len(backend.Calls)-1]; !reflect.DeepEqual(call.Cmd, []interface{}{"rollback", "foo", "1"}) {
+		t.Fatalf("Unexpected rollback: %v", call.Cmd)
+	}
+
+	if err := foo.Rollback(5, RunOptions{}).Err(); err == nil || err.Error() != "foo: Release foo has no revision 5" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Apps that were never applied have no history
+	if _, results := apps[1:].History(0, RunOptions{}); results.Err() == nil {
+		t.Fatal("History of a missing release did not fail")
+	}
+}
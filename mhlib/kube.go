@@ -0,0 +1,251 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/codeskyblue/go-sh"
+)
+
+// Context modes define how mh selects the Kubernetes context it operates on.
+const (
+	// ContextModeRequireCurrent requires the current kubectl context to be the
+	// TargetContext and lets Helm and kubectl use it.
+	ContextModeRequireCurrent = "require-current"
+	// ContextModeExplicit passes the TargetContext to every Helm and kubectl
+	// command, regardless of the current context.
+	ContextModeExplicit = "explicit"
+)
+
+// KubeOptions select the cluster Helm and kubectl talk to. Empty options fall
+// back to the current context of the default kubeconfig.
+type KubeOptions struct {
+	Context    string
+	Kubeconfig string
+}
+
+// NewKubeOptions returns the KubeOptions for the context mode, TargetContext
+// and Kubeconfig of a MHConfig. Contexts from TargetContexts are always passed
+// explicitly.
+func NewKubeOptions(config MHConfig) (KubeOptions, error) {
+	options := KubeOptions{Kubeconfig: config.Kubeconfig}
+
+	switch config.ContextMode {
+	case "", ContextModeRequireCurrent:
+		// Only one context can be the current one
+		if len(config.TargetContexts) > 0 {
+			options.Context = config.TargetContext
+		}
+	case ContextModeExplicit:
+		options.Context = config.TargetContext
+	default:
+		return options, fmt.Errorf("Unsupported contextMode: %s", config.ContextMode)
+	}
+
+	return options, nil
+}
+
+// helmFlags appends the global Helm flags selecting the cluster to a Helm
+// command.
+func (o KubeOptions) helmFlags(cmd []interface{}) []interface{} {
+	if o.Context != "" {
+		cmd = append(cmd, "--kube-context", o.Context)
+	}
+	if o.Kubeconfig != "" {
+		cmd = append(cmd, "--kubeconfig", o.Kubeconfig)
+	}
+
+	return cmd
+}
+
+// kubectlFlags appends the global kubectl flags selecting the cluster to a
+// kubectl command.
+func (o KubeOptions) kubectlFlags(cmd []interface{}) []interface{} {
+	if o.Context != "" {
+		cmd = append(cmd, "--context", o.Context)
+	}
+	if o.Kubeconfig != "" {
+		cmd = append(cmd, "--kubeconfig", o.Kubeconfig)
+	}
+
+	return cmd
+}
+
+// kubectlOutput runs a kubectl command against the cluster of KubeOptions and
+// returns its output. Errors contain kubectl's error output.
+func kubectlOutput(options KubeOptions, args ...interface{}) ([]byte, error) {
+	return kubectlInputOutput(options, "", args...)
+}
+
+// kubectlInputOutput runs a kubectl command like kubectlOutput, passing input
+// to its stdin.
+func kubectlInputOutput(options KubeOptions, input string, args ...interface{}) ([]byte, error) {
+	var stderr bytes.Buffer
+	session := sh.NewSession()
+	session.Stderr = &stderr
+	if input != "" {
+		session.SetInput(input)
+	}
+
+	out, err := session.Command("kubectl", options.kubectlFlags(args)...).Output()
+	if err != nil {
+		return out, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// CheckContext verifies that mh can operate on the TargetContext of a
+// MHConfig. With ContextModeRequireCurrent it must be the current kubectl
+// context, otherwise it must exist in the kubeconfig.
+func CheckContext(config MHConfig) error {
+	options, err := NewKubeOptions(config)
+	if err != nil {
+		return err
+	}
+
+	if options.Context == "" {
+		out, err := kubectlOutput(options, "config", "current-context")
+		if err != nil {
+			return fmt.Errorf("Failed running `kubectl config current-context`: %v", err)
+		}
+		if currentContext := strings.TrimSpace(string(out)); currentContext != config.TargetContext {
+			return fmt.Errorf("Current kubectl context %s does not match targetContext %s", currentContext, config.TargetContext)
+		}
+		return nil
+	}
+
+	out, err := kubectlOutput(KubeOptions{Kubeconfig: options.Kubeconfig}, "config", "get-contexts", "--output", "name")
+	if err != nil {
+		return fmt.Errorf("Failed running `kubectl config get-contexts`: %v", err)
+	}
+	for _, context := range strings.Fields(string(out)) {
+		if context == options.Context {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Context %s does not exist in kubeconfig", options.Context)
+}
+
+// KubeObject identifies a Kubernetes object.
+type KubeObject struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// String returns the object like "Deployment namespace/name", the way
+// resources are named in diffs.
+func (o KubeObject) String() string {
+	if o.Namespace == "" {
+		return o.Kind + " " + o.Name
+	}
+
+	return o.Kind + " " + o.Namespace + "/" + o.Name
+}
+
+// KubeClient is what an App uses to read and write objects in Kubernetes.
+type KubeClient interface {
+	// Get returns the current state of objects as JSON. Objects that do not
+	// exist are left out.
+	Get(objects []KubeObject) ([][]byte, error)
+	// Apply creates or updates an object given as JSON.
+	Apply(object []byte) error
+	// Delete deletes an object, if it exists.
+	Delete(object KubeObject) error
+}
+
+// KubectlClient is a KubeClient running kubectl.
+type KubectlClient struct {
+	options KubeOptions
+}
+
+// NewKubectlClient returns a KubectlClient operating on the cluster selected
+// by KubeOptions.
+func NewKubectlClient(options KubeOptions) *KubectlClient {
+	return &KubectlClient{options: options}
+}
+
+// Get runs `kubectl get` with JSON output once per namespace.
+func (c *KubectlClient) Get(objects []KubeObject) ([][]byte, error) {
+	var namespaces []string
+	names := map[string][]interface{}{}
+	for _, object := range objects {
+		if _, ok := names[object.Namespace]; !ok {
+			namespaces = append(namespaces, object.Namespace)
+		}
+		names[object.Namespace] = append(names[object.Namespace], strings.ToLower(object.Kind)+"/"+object.Name)
+	}
+
+	var items [][]byte
+	for _, namespace := range namespaces {
+		cmd := append([]interface{}{"get"}, names[namespace]...)
+		if namespace != "" {
+			cmd = append(cmd, "--namespace", namespace)
+		}
+		out, err := kubectlOutput(c.options, append(cmd, "--output", "json", "--ignore-not-found")...)
+		if err != nil {
+			return nil, fmt.Errorf("Failed running `kubectl get`: %v", err)
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			continue
+		}
+
+		// kubectl returns a single object as is and multiple ones as a List
+		var list struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(out, &list); err != nil {
+			return nil, fmt.Errorf("Failed to parse `kubectl get` output: %v", err)
+		}
+		if list.Kind != "List" {
+			items = append(items, out)
+			continue
+		}
+		for _, item := range list.Items {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// Apply runs `kubectl apply`, reading the object from stdin.
+func (c *KubectlClient) Apply(object []byte) error {
+	if _, err := kubectlInputOutput(c.options, string(object), "apply", "--filename", "-"); err != nil {
+		return fmt.Errorf("Failed running `kubectl apply`: %v", err)
+	}
+
+	return nil
+}
+
+// Delete runs `kubectl delete`, ignoring objects that do not exist.
+func (c *KubectlClient) Delete(object KubeObject) error {
+	cmd := []interface{}{"delete", strings.ToLower(object.Kind) + "/" + object.Name, "--ignore-not-found"}
+	if object.Namespace != "" {
+		cmd = append(cmd, "--namespace", object.Namespace)
+	}
+	if _, err := kubectlOutput(c.options, cmd...); err != nil {
+		return fmt.Errorf("Failed running `kubectl delete`: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewKubeOptions(t *testing.T) {
+	config := DefaultMHConfig
+	config.TargetContext = "prod"
+	config.Kubeconfig = "/kube/config"
+
+	options, err := NewKubeOptions(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options != (KubeOptions{Kubeconfig: "/kube/config"}) {
+		t.Fatalf("Unexpected options in require-current mode: %+v", options)
+	}
+
+	config.ContextMode = ContextModeExplicit
+	backend, err := NewReleaseBackend(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := backend.(*HelmV2Backend).upgradeCmd(UpgradeOptions{Release: "foo", Chart: "stable/foo"})
+	expected := []interface{}{"upgrade", "foo", "stable/foo", "--values", "-", "--kube-context", "prod", "--kubeconfig", "/kube/config"}
+	if !reflect.DeepEqual(cmd, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v", cmd, expected)
+	}
+
+	config.ContextMode = "current"
+	if _, err := NewKubeOptions(config); err == nil {
+		t.Fatal("Unsupported context mode was accepted")
+	}
+}
+
+func TestCheckContext(t *testing.T) {
+	// Fake kubectl knowing the contexts dev (current) and prod
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubectl := `#!/bin/sh
+case "$*" in
+  "config current-context"*) echo dev ;;
+  "config get-contexts --output name"*) printf 'dev\nprod\n' ;;
+  *) exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubectl"), []byte(kubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, test := range []struct {
+		mode, context string
+		ok            bool
+	}{
+		{ContextModeRequireCurrent, "dev", true},
+		{ContextModeRequireCurrent, "prod", false},
+		{ContextModeExplicit, "prod", true},
+		{ContextModeExplicit, "staging", false},
+	} {
+		config := DefaultMHConfig
+		config.ContextMode = test.mode
+		config.TargetContext = test.context
+		err := CheckContext(config)
+		if (err == nil) != test.ok {
+			t.Errorf("Context %s in mode %s: %v", test.context, test.mode, err)
+		}
+	}
+}
@@ -22,13 +22,28 @@ import (
 
 // MHConfig is a set of options used during app deployment.
 type MHConfig struct {
+	ContextMode    string   `yaml:"contextMode"`
+	HelmVersion    int      `yaml:"helmVersion"`
+	Kubeconfig     string   `yaml:"kubeconfig"`
 	Maintainers    []string `yaml:"maintainers"`
 	PrintRendered  bool     `yaml:"printRendered"`
+	PrintSecrets   bool     `yaml:"printSecrets"`
+	NoProvenance   bool     `yaml:"noProvenance"`
 	NoRecreatePods bool     `yaml:"noRecreatePods"`
-	Simulate       bool     `yaml:"simulate"`
-	TargetContext  string   `yaml:"targetContext"`
-	Team           string   `yaml:"team"`
-	SETValues      []string
+	// Owner identifies the mh config owning the releases of apps, so configs
+	// sharing a cluster only prune their own releases. It defaults to the
+	// config's path in its git remote, or to its absolute path without one.
+	Owner             string   `yaml:"owner"`
+	Parallelism       int      `yaml:"parallelism"`
+	RollbackOnFailure bool     `yaml:"rollbackOnFailure"`
+	SensitiveKeys     []string `yaml:"sensitiveKeys"`
+	Simulate          bool     `yaml:"simulate"`
+	TargetContext     string   `yaml:"targetContext"`
+	TargetContexts    []string `yaml:"targetContexts"`
+	Team              string   `yaml:"team"`
+	Wait              bool     `yaml:"wait"`
+	WaitTimeout       string   `yaml:"waitTimeout"`
+	SETValues         []string
 }
 
 // DefaultMHConfig is the default mh config and will most likely be modified
@@ -40,13 +55,32 @@ type MHConfig struct {
 // 4. Command line flags
 // 5. app-specific overrides in MH_CONFIG.
 var DefaultMHConfig = MHConfig{
-	Maintainers:    []string{"none"},
-	PrintRendered:  false,
-	NoRecreatePods: false,
-	Simulate:       false,
-	TargetContext:  "localhost",
-	Team:           "sre",
-	SETValues:      []string{""},
+	ContextMode:       ContextModeRequireCurrent,
+	HelmVersion:       2,
+	Maintainers:       []string{"none"},
+	PrintRendered:     false,
+	PrintSecrets:      false,
+	NoProvenance:      false,
+	NoRecreatePods:    false,
+	Parallelism:       1,
+	RollbackOnFailure: false,
+	SensitiveKeys:     []string{},
+	Simulate:          false,
+	TargetContext:     "localhost",
+	Team:              "sre",
+	Wait:              false,
+	WaitTimeout:       "5m",
+	SETValues:         []string{""},
+}
+
+// Contexts returns the kubectl contexts a MHConfig targets: TargetContexts if
+// set, TargetContext otherwise.
+func (c *MHConfig) Contexts() []string {
+	if len(c.TargetContexts) > 0 {
+		return c.TargetContexts
+	}
+
+	return []string{c.TargetContext}
 }
 
 // MergeMHConfigs merges an arbitrary number of MHConfigs with rising priority.
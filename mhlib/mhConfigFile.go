@@ -16,31 +16,29 @@ package mhlib
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
 // MHConfigFile is the structure of a mh configuration file.
 type MHConfigFile struct {
-	// TargetContext for backwards compatibility
-	TargetContext string           `yaml:"targetContext"`
-	MH            MHConfig         `yaml:"mh"`
-	Apps          AppConfigs       `yaml:"apps"`
-	AppSources    AppSourceConfigs `yaml:"appSources"`
+	// TargetContext for backwards compatibility. TargetContexts target all
+	// apps at multiple contexts like in MH.
+	TargetContext  string           `yaml:"targetContext"`
+	TargetContexts []string         `yaml:"targetContexts"`
+	MH             MHConfig         `yaml:"mh"`
+	Apps           AppConfigs       `yaml:"apps"`
+	AppSources     AppSourceConfigs `yaml:"appSources"`
+	// Strict fails on apps defined by multiple AppSources of the same priority
+	// instead of using the first one.
+	Strict bool `yaml:"strict"`
 }
 
-// EffectiveApps returns all Apps that are configured in a MHConfigFile,
-// optionally filtering them with given expressions. It matches them against the
-// MHConfigFiles AppSourceConfigs, if their File is not overridden. Also passes
-// a given logger and effective MHConfig down to them.
-//
-// Todo: Support more than simple names as filter. Improve the below algorithm.
-func (c *MHConfigFile) EffectiveApps(logger *logrus.Entry, configFile string, filters []string, effectiveMHConfig MHConfig) (*Apps, error) {
+// EffectiveAppSources returns the AppSources defined in a MHConfigFile with
+// their AppFiles.
+func (c *MHConfigFile) EffectiveAppSources(logger *logrus.Entry, configFile string) (AppSources, error) {
 	var effectiveAppSources AppSources
-	var effectiveApps Apps
-
-	// Build effective app sources from AppSourceConfigs defined in configuration
-	// file.
 	for _, appSourceConfig := range c.AppSources {
 		appSource, err := NewAppSource(appSourceConfig, configFile)
 		if err != nil {
@@ -54,62 +52,117 @@ func (c *MHConfigFile) EffectiveApps(logger *logrus.Entry, configFile string, fi
 		effectiveAppSources = append(effectiveAppSources, *appSource)
 	}
 
-	// Build effective apps from AppConfigs given in configuration file, matching
-	// them with effective AppSources and MHConfig built above.
+	return effectiveAppSources, nil
+}
+
+// EffectiveApps returns all Apps that are configured in a MHConfigFile,
+// optionally filtering them with a Selector and adding the apps they depend
+// on. It matches them against the MHConfigFiles AppSourceConfigs, if their
+// File is not overridden. Also passes a given logger and effective MHConfig
+// down to them. Apps are returned in dependency order.
+func (c *MHConfigFile) EffectiveApps(logger *logrus.Entry, configFile string, selector Selector, withDeps bool, effectiveMHConfig MHConfig) (*Apps, error) {
+	var effectiveApps Apps
+
+	// Build effective app sources from AppSourceConfigs defined in configuration
+	// file.
+	effectiveAppSources, err := c.EffectiveAppSources(logger, configFile)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, appConfig := range c.Apps {
-		// If no filters are defined, add the app immediately
-		if len(filters) == 0 {
+		if appConfig.Protect != nil {
+			return nil, fmt.Errorf("App %s sets protect, which is not supported, set protected instead", appConfig.id())
+		}
+	}
+
+	// Build the dependency graph of all configured apps, so it is validated
+	// regardless of filters.
+	graph, unknown := newAppGraph(c.Apps)
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("Apps depend on unknown apps: %s", strings.Join(unknown, ", "))
+	}
+	if cycle := graph.cycle(); cycle != nil {
+		return nil, cycleError(cycle)
+	}
+
+	// Select the apps to build. If no filters are defined, select all apps.
+	selected, err := selector.selectApps(c.Apps, effectiveMHConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !selector.Empty() {
+		for i, appConfig := range c.Apps {
+			if selected[i] {
+				logger.WithField("app", appConfig.Name).Info("App matched filter")
+			}
+		}
+	}
+
+	// Select the dependencies of selected apps if requested
+	if withDeps {
+		for i := range graph.dependencies(selected) {
+			if !selected[i] {
+				logger.WithField("app", c.Apps[i].Name).Info("App selected as dependency")
+				selected[i] = true
+			}
+		}
+	}
+
+	waves, err := graph.waves()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build effective apps from selected AppConfigs in dependency order,
+	// matching them with effective AppSources and MHConfig built above.
+	for _, wave := range waves {
+		for _, i := range wave {
+			if !selected[i] {
+				continue
+			}
+			appConfig := c.Apps[i]
+
 			// Match the app config with configured app sources if File is not
 			// overridden.
 			if appConfig.File == nil {
-				if !effectiveAppSources.File(&appConfig) {
+				matches := effectiveAppSources.Resolve(appConfig.Name)
+				if len(matches) == 0 {
 					logger.WithFields(logrus.Fields{
 						"app": appConfig.Name,
 					}).Error("App not found in sources")
 					return nil, fmt.Errorf("App not found in sources")
 				}
+
+				if matches.Ambiguous() {
+					if c.Strict {
+						return nil, fmt.Errorf("App %s is defined by multiple AppSources of the same priority: %s",
+							appConfig.Name, strings.Join(matches.Names(), ", "))
+					}
+					logger.WithFields(logrus.Fields{
+						"app":        appConfig.Name,
+						"appSources": matches.Names(),
+					}).Warn("App is defined by multiple AppSources of the same priority, using the first")
+				}
+
+				matches[0].File(&appConfig)
+				logger.WithFields(logrus.Fields{
+					"app":       appConfig.Name,
+					"appSource": matches[0].Name,
+					"shadowed":  matches[1:].Names(),
+				}).Info("App found in source")
 			}
 
 			app, err := NewApp(logger, appConfig, effectiveMHConfig)
 			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"app":   appConfig.Name,
+					"error": err,
+				}).Error("App creation failed")
 				return nil, err
 			}
 
 			effectiveApps = append(effectiveApps, *app)
-		} else {
-			// If filters are defined, try to match against them
-			for _, filter := range filters {
-				// Add the app only if the filter matches its Name or Alias
-				if appConfig.Name == filter || appConfig.Alias == filter {
-					logger.WithFields(logrus.Fields{
-						"app":    appConfig.Name,
-						"filter": filter,
-					}).Info("App matched filter")
-
-					// Match the app config with configured app sources if File is not
-					// overridden.
-					if appConfig.File == nil {
-						if !effectiveAppSources.File(&appConfig) {
-							logger.WithFields(logrus.Fields{
-								"app": appConfig.Name,
-							}).Error("App not found in sources")
-							return nil, fmt.Errorf("App not found in sources")
-						}
-					}
-
-					app, err := NewApp(logger, appConfig, effectiveMHConfig)
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"app":   appConfig.Name,
-							"error": err,
-						}).Error("App creation failed")
-						return nil, err
-					}
-
-					effectiveApps = append(effectiveApps, *app)
-					break
-				}
-			}
 		}
 	}
 
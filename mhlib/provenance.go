@@ -0,0 +1,364 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// provenanceLabel labels the ConfigMaps mh records the provenance of releases
+// in.
+const provenanceLabel = "app.kubernetes.io/managed-by"
+
+// ReleaseProvenance is where a revision of a release was applied from. mh
+// records it in a ConfigMap next to each release it applies, keeping the
+// release's values as they were rendered. Owner identifies the mh config
+// owning the release, to prune it once its app is removed from the config,
+// unless it is Protected.
+type ReleaseProvenance struct {
+	MHVersion  string `json:"mhVersion"`
+	ConfigFile string `json:"configFile"`
+	// ConfigHash is the SHA-256 of the composed mh configuration before
+	// self-rendering.
+	ConfigHash string   `json:"configHash"`
+	GitSHA     string   `json:"gitSHA,omitempty"`
+	GitDirty   bool     `json:"gitDirty,omitempty"`
+	User       string   `json:"user,omitempty"`
+	SETValues  []string `json:"setValues,omitempty"`
+	Owner      string   `json:"owner,omitempty"`
+	Protected  bool     `json:"protected,omitempty"`
+}
+
+// NewReleaseProvenance returns the provenance of releases applied with a
+// RenderedConfig by a mh version. The git SHA is left empty if the
+// configuration is not in a git repository. Releases are owned by the
+// configuration's default owner unless Apps set an owner.
+func NewReleaseProvenance(config *RenderedConfig, mhVersion string) *ReleaseProvenance {
+	provenance := &ReleaseProvenance{
+		MHVersion:  mhVersion,
+		ConfigFile: config.File,
+	}
+	if file, err := filepath.Abs(config.File); err == nil {
+		provenance.ConfigFile = file
+	}
+	provenance.Owner = defaultOwner(provenance.ConfigFile)
+
+	if len(config.Passes) > 0 {
+		sum := sha256.Sum256([]byte(config.Passes[0]))
+		provenance.ConfigHash = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	dir := filepath.Dir(provenance.ConfigFile)
+	if out, err := gitOutput(dir, "rev-parse", "HEAD"); err == nil {
+		provenance.GitSHA = strings.TrimSpace(string(out))
+		if out, err := gitOutput(dir, "status", "--porcelain"); err == nil {
+			provenance.GitDirty = len(strings.TrimSpace(string(out))) > 0
+		}
+	}
+
+	if current, err := user.Current(); err == nil {
+		provenance.User = current.Username
+	} else {
+		provenance.User = os.Getenv("USER")
+	}
+
+	return provenance
+}
+
+// defaultOwner returns the owner of releases applied with a configuration
+// file: its path in the origin remote of its git repository, e.g.
+// "github.com/org/clusters/prod.yaml", which is the same in every checkout.
+// Outside of git repositories or without a remote, it is the file's path.
+func defaultOwner(file string) string {
+	dir := filepath.Dir(file)
+	remote, err := gitOutput(dir, "config", "--get", "remote.origin.url")
+	if err != nil {
+		return file
+	}
+	prefix, err := gitOutput(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return file
+	}
+
+	return path.Join(remoteName(strings.TrimSpace(string(remote))), strings.TrimSpace(string(prefix)), filepath.Base(file))
+}
+
+// remoteName returns the host and path of a git remote URL, so HTTPS and SSH
+// URLs of a repository have the same name, e.g. "github.com/org/clusters" for
+// "git@github.com:org/clusters.git".
+func remoteName(url string) string {
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+len("://"):]
+	} else if i := strings.Index(url, ":"); i >= 0 {
+		url = url[:i] + "/" + url[i+1:]
+	}
+	if i := strings.Index(url, "@"); i >= 0 && i < strings.Index(url+"/", "/") {
+		url = url[i+1:]
+	}
+
+	return url
+}
+
+// owner returns the owner of an App's release.
+func (p *ReleaseProvenance) owner(a *App) string {
+	if a.Owner != "" {
+		return a.Owner
+	}
+
+	return p.Owner
+}
+
+// forApp returns the provenance of an App's release. Secrets in its --set
+// values are masked.
+func (p *ReleaseProvenance) forApp(a *App, redactor *Redactor) *ReleaseProvenance {
+	provenance := *p
+	provenance.Owner = p.owner(a)
+	provenance.Protected = a.Protected
+	provenance.SETValues = nil
+	for _, setValue := range a.SETValues {
+		if setValue != "" {
+			provenance.SETValues = append(provenance.SETValues, redactor.redactSetValue(setValue))
+		}
+	}
+
+	return &provenance
+}
+
+// provenanceObject returns the ConfigMap the provenance of a release's
+// revisions is recorded in, in the namespace of the release.
+func provenanceObject(release, namespace string) KubeObject {
+	return KubeObject{Kind: "ConfigMap", Namespace: namespace, Name: release + "-mh-provenance"}
+}
+
+// revisionProvenances are the provenances recorded for the revisions of a
+// release, by revision.
+type revisionProvenances map[int]*ReleaseProvenance
+
+// readProvenances returns the provenances recorded for the revisions of a
+// release. There are none if mh did not apply the release.
+func readProvenances(kube KubeClient, release, namespace string) (revisionProvenances, error) {
+	items, err := kube.Get([]KubeObject{provenanceObject(release, namespace)})
+	if err != nil {
+		return nil, err
+	}
+
+	provenances := revisionProvenances{}
+	if len(items) == 0 {
+		return provenances, nil
+	}
+
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(items[0], &configMap); err != nil {
+		return nil, fmt.Errorf("Failed to parse provenance of release %s: %v", release, err)
+	}
+	for key, data := range configMap.Data {
+		revision, err := strconv.Atoi(strings.TrimPrefix(key, "v"))
+		if err != nil || !strings.HasPrefix(key, "v") {
+			continue
+		}
+		var provenance ReleaseProvenance
+		if err := json.Unmarshal([]byte(data), &provenance); err != nil {
+			return nil, fmt.Errorf("Failed to parse provenance of revision %d of release %s: %v", revision, release, err)
+		}
+		provenances[revision] = &provenance
+	}
+
+	return provenances, nil
+}
+
+// latest returns the provenance of the latest recorded revision, or nil if
+// none was recorded.
+func (p revisionProvenances) latest() *ReleaseProvenance {
+	latest := 0
+	for revision := range p {
+		if revision > latest {
+			latest = revision
+		}
+	}
+
+	return p[latest]
+}
+
+// write records the provenances of a release's revisions, replacing the ones
+// recorded before.
+func (p revisionProvenances) write(kube KubeClient, release, namespace string) error {
+	data := map[string]string{}
+	for revision, provenance := range p {
+		out, err := json.Marshal(provenance)
+		if err != nil {
+			return err
+		}
+		data["v"+strconv.Itoa(revision)] = string(out)
+	}
+
+	object := provenanceObject(release, namespace)
+	metadata := map[string]interface{}{
+		"name":   object.Name,
+		"labels": map[string]string{provenanceLabel: "mh"},
+	}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	configMap, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       object.Kind,
+		"metadata":   metadata,
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := kube.Apply(configMap); err != nil {
+		return fmt.Errorf("Failed to record provenance of release %s: %v", release, err)
+	}
+
+	return nil
+}
+
+// recordProvenance records a provenance for the latest revision of the App's
+// release, e.g. the one just applied or rolled back to. Provenances of
+// revisions Helm no longer keeps are forgotten.
+func (a *App) recordProvenance(provenance *ReleaseProvenance) error {
+	revisions, _, err := a.Backend.History(a.ID, a.Namespace)
+	if err != nil {
+		return fmt.Errorf("Failed to get release history to record provenance: %v", err)
+	}
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	provenances, err := readProvenances(a.Kube, a.ID, a.Namespace)
+	if err != nil {
+		return err
+	}
+	kept := map[int]bool{}
+	for _, revision := range revisions {
+		kept[revision.Revision] = true
+	}
+	for revision := range provenances {
+		if !kept[revision] {
+			delete(provenances, revision)
+		}
+	}
+	provenances[revisions[len(revisions)-1].Revision] = provenance
+
+	return provenances.write(a.Kube, a.ID, a.Namespace)
+}
+
+// recordRollbackProvenance records the provenance of the revision the App's
+// release was rolled back to for the latest revision, if any was recorded.
+func (a *App) recordRollbackProvenance(revision int) error {
+	provenances, err := readProvenances(a.Kube, a.ID, a.Namespace)
+	if err != nil {
+		return err
+	}
+	if provenances[revision] == nil {
+		return nil
+	}
+
+	return a.recordProvenance(provenances[revision])
+}
+
+// forgetProvenance deletes the provenance recorded for a purged release, so a
+// release installed with the same name later does not inherit it.
+func forgetProvenance(kube KubeClient, release, namespace string) error {
+	if err := kube.Delete(provenanceObject(release, namespace)); err != nil {
+		return fmt.Errorf("Failed to delete provenance of release %s: %v", release, err)
+	}
+
+	return nil
+}
+
+// AppProvenance is the provenance recorded in the deployed release of an App,
+// if any.
+type AppProvenance struct {
+	App        string             `json:"app"`
+	Context    string             `json:"context"`
+	Provenance *ReleaseProvenance `json:"provenance"`
+}
+
+// Provenance returns the provenance recorded for the deployed revision of the
+// App's release.
+func (a *App) Provenance() (*AppProvenance, *[]interface{}, error) {
+	deployed, err := a.deployedRevision()
+	if err != nil {
+		return nil, nil, err
+	}
+	if deployed == nil {
+		return nil, nil, ErrReleaseNotFound
+	}
+	provenances, err := readProvenances(a.Kube, a.ID, a.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &AppProvenance{
+		App:        a.ID,
+		Context:    a.TargetContext,
+		Provenance: provenances[deployed.Revision],
+	}, nil, nil
+}
+
+// AppProvenances are the provenances of Apps.
+type AppProvenances []*AppProvenance
+
+// PrintTable prints the AppProvenances as a human readable table. Apps
+// without recorded provenance have empty columns.
+func (p AppProvenances) PrintTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tAPP\tMH VERSION\tUSER\tGIT SHA\tGIT DIRTY\tCONFIG HASH\tCONFIG FILE\tSET VALUES")
+	for _, appProvenance := range p {
+		provenance := appProvenance.Provenance
+		if provenance == nil {
+			provenance = &ReleaseProvenance{}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			appProvenance.Context,
+			appProvenance.App,
+			provenance.MHVersion,
+			provenance.User,
+			provenance.GitSHA,
+			strconv.FormatBool(provenance.GitDirty),
+			provenance.ConfigHash,
+			provenance.ConfigFile,
+			strings.Join(provenance.SETValues, ","),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintJSON prints the AppProvenances as a JSON document.
+func (p AppProvenances) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
@@ -0,0 +1,160 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppsApplyProvenance(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	kube := apps[0].Kube.(*FakeKubeClient)
+	config.Provenance = NewReleaseProvenance(config, "v1.2.3")
+	if !strings.HasPrefix(config.Provenance.ConfigHash, "sha256:") || config.Provenance.ConfigFile != config.File {
+		t.Fatalf("Unexpected provenance: %+v", config.Provenance)
+	}
+	apps[0].SETValues = []string{"image.tag=2.1", ""}
+	apps[1].NoProvenance = true
+
+	for _, sha := range []string{"abc", "def"} {
+		config.Provenance.GitSHA = sha
+		if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Provenance is recorded per revision next to the release, not in its
+	// values
+	provenances, err := readProvenances(kube, "foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provenances) != 2 || provenances[1].GitSHA != "abc" || provenances[2].GitSHA != "def" ||
+		provenances[2].MHVersion != "v1.2.3" || !reflect.DeepEqual(provenances[2].SETValues, []string{"image.tag=2.1"}) {
+		t.Fatalf("Unexpected recorded provenances: %+v", provenances)
+	}
+	if strings.Contains(string(backend.Values["foo"]), "abc") || strings.Contains(string(backend.Values["foo"]), "mhProvenance") {
+		t.Fatalf("Provenance was recorded in values: %s", backend.Values["foo"])
+	}
+	if _, ok := kube.Objects["ConfigMap baz-mh-provenance"]; ok {
+		t.Fatal("Provenance was recorded for an app opting out")
+	}
+
+	appProvenances, results := apps.Provenance(RunOptions{})
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(appProvenances) != 2 || appProvenances[0].Provenance.GitSHA != "def" || appProvenances[1].Provenance != nil {
+		t.Fatalf("Unexpected provenances: %+v", appProvenances)
+	}
+
+	histories, _ := apps[:1].History(0, RunOptions{})
+	if histories[0].Revisions[0].GitSHA != "abc" || histories[0].Revisions[1].GitSHA != "def" {
+		t.Fatalf("Unexpected history: %+v", histories[0])
+	}
+
+	// Rolling back records the provenance of the revision rolled back to,
+	// purging forgets it
+	if err := apps[:1].Rollback(1, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if provenances, _ := readProvenances(kube, "foo", ""); provenances[3] == nil || provenances[3].GitSHA != "abc" {
+		t.Fatalf("Unexpected provenances after rollback: %+v", provenances)
+	}
+	if err := apps[:1].Destroy(DestroyOptions{Purge: true}, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if provenances, _ := readProvenances(kube, "foo", ""); len(provenances) != 0 {
+		t.Fatalf("Provenance of purged release was kept: %+v", provenances)
+	}
+}
+
+func TestDefaultOwner(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Outside of git repositories releases are owned by the config's path
+	file := filepath.Join(dir, "prod", "main.yaml")
+	if owner := defaultOwner(file); owner != file {
+		t.Fatalf("Unexpected owner outside of git: %s", owner)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, remote := range []string{"git@github.com:org/clusters.git", "https://github.com/org/clusters", "ssh://git@github.com/org/clusters.git"} {
+		if _, err := gitOutput(dir, "init", "--quiet"); err != nil {
+			t.Fatal(err)
+		}
+		gitOutput(dir, "remote", "remove", "origin")
+		if _, err := gitOutput(dir, "remote", "add", "origin", remote); err != nil {
+			t.Fatal(err)
+		}
+		if owner := defaultOwner(file); owner != "github.com/org/clusters/prod/main.yaml" {
+			t.Fatalf("Unexpected owner for remote %s: %s", remote, owner)
+		}
+	}
+}
+
+func TestProvenanceKubectl(t *testing.T) {
+	// Fake kubectl keeping the applied ConfigMap in a file next to it
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubectl := `#!/bin/sh
+state="$(dirname "$0")/configmap.json"
+case "$*" in
+  "apply --filename -") cat > "$state" ;;
+  "get configmap/foo-mh-provenance --namespace apps --output json --ignore-not-found") cat "$state" 2>/dev/null || true ;;
+  "delete configmap/foo-mh-provenance --ignore-not-found --namespace apps") rm -f "$state" ;;
+  *) echo "Unexpected arguments: $*" >&2; exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubectl"), []byte(kubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	kube := NewKubectlClient(KubeOptions{})
+	if err := (revisionProvenances{2: {GitSHA: "abc"}}).write(kube, "foo", "apps"); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := ioutil.ReadFile(filepath.Join(dir, "configmap.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"kind":"ConfigMap"`, `"name":"foo-mh-provenance"`, `"namespace":"apps"`, `"app.kubernetes.io/managed-by":"mh"`, `"v2":`} {
+		if !strings.Contains(string(applied), expected) {
+			t.Fatalf("Applied ConfigMap does not contain %s: %s", expected, applied)
+		}
+	}
+
+	provenances, err := readProvenances(kube, "foo", "apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provenances) != 1 || provenances[2].GitSHA != "abc" {
+		t.Fatalf("Unexpected provenances: %+v", provenances)
+	}
+
+	if err := forgetProvenance(kube, "foo", "apps"); err != nil {
+		t.Fatal(err)
+	}
+	if provenances, err := readProvenances(kube, "foo", "apps"); err != nil || len(provenances) != 0 {
+		t.Fatalf("Provenance was not deleted: %+v (%v)", provenances, err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// unconfiguredRelease is a release in a cluster without an App, along with its
+// recorded provenance.
+type unconfiguredRelease struct {
+	Release
+	provenance *ReleaseProvenance
+}
+
+// unconfiguredReleases returns the releases with mh provenance recorded in the
+// cluster of the Apps that have no App. All Apps must target the same context.
+// Apps opting out of provenance cannot be told apart from releases mh does not
+// manage.
+func (a Apps) unconfiguredReleases() ([]unconfiguredRelease, *[]interface{}, error) {
+	if len(a) == 0 {
+		return nil, nil, nil
+	}
+
+	apps := map[string]bool{}
+	for _, app := range a {
+		apps[app.ID] = true
+	}
+
+	backend := a[0].Backend
+	releases, cmd, err := backend.List()
+	if err != nil {
+		return nil, cmd, err
+	}
+
+	var unconfigured []unconfiguredRelease
+	for _, release := range releases {
+		if apps[release.Name] {
+			continue
+		}
+
+		provenances, err := readProvenances(a[0].Kube, release.Name, release.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		if provenance := provenances.latest(); provenance != nil {
+			unconfigured = append(unconfigured, unconfiguredRelease{release, provenance})
+		}
+	}
+
+	return unconfigured, nil, nil
+}
+
+// PrunableRelease is a release owned by a mh config that no longer has an App
+// for it.
+type PrunableRelease struct {
+	Release
+	Context string
+	// Protected releases are listed but not pruned.
+	Protected bool
+
+	backend ReleaseBackend
+	kube    KubeClient
+}
+
+// PrunableReleases are releases to prune.
+type PrunableReleases []PrunableRelease
+
+// Prunable returns the releases in the clusters of the Apps owned by an owner
+// of the Apps in the same cluster, that have no App. Releases in clusters no
+// App targets anymore are not found.
+func (a Apps) Prunable(config *RenderedConfig) (PrunableReleases, Results) {
+	var (
+		prunable PrunableReleases
+		results  Results
+	)
+	if config.Provenance == nil {
+		return nil, Results{{App: "prune", Action: "prune", Error: fmt.Errorf("Releases are not owned without provenance")}}
+	}
+
+	for _, cluster := range a.clusters() {
+		context := cluster[0].TargetContext
+		owners := map[string]bool{}
+		for i := range cluster {
+			owners[config.Provenance.owner(&cluster[i])] = true
+		}
+
+		unconfigured, cmd, err := cluster.unconfiguredReleases()
+		if err != nil {
+			result := Result{App: "prune", Context: context, Action: "prune", Error: err}
+			if cmd != nil {
+				result.Cmd = *cmd
+			}
+			results = append(results, result)
+			continue
+		}
+
+		for _, release := range unconfigured {
+			if !owners[release.provenance.Owner] {
+				continue
+			}
+			prunable = append(prunable, PrunableRelease{
+				Release:   release.Release,
+				Context:   context,
+				Protected: release.provenance.Protected,
+				backend:   cluster[0].Backend,
+				kube:      cluster[0].Kube,
+			})
+		}
+	}
+
+	return prunable, results
+}
+
+// Prune purges each release that is not protected, one after another. Their
+// apps are gone, so no App depends on them anymore.
+func (p PrunableReleases) Prune() Results {
+	var results Results
+	for _, release := range p {
+		if release.Protected {
+			continue
+		}
+
+		start := time.Now()
+		cmd, err := release.backend.Delete(release.Name, release.Namespace, true)
+		if err == nil {
+			err = forgetProvenance(release.kube, release.Name, release.Namespace)
+		}
+		result := Result{
+			App:      release.Name,
+			Context:  release.Context,
+			Action:   "prune",
+			Duration: time.Since(start),
+			Error:    err,
+		}
+		if cmd != nil {
+			result.Cmd = *cmd
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// PrintTable prints the PrunableReleases as a human readable table.
+func (p PrunableReleases) PrintTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tRELEASE\tNAMESPACE\tCHART\tPROTECTED")
+	for _, release := range p {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			release.Context,
+			release.Name,
+			release.Namespace,
+			release.Chart,
+			strconv.FormatBool(release.Protected),
+		)
+	}
+
+	return tw.Flush()
+}
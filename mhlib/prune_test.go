@@ -0,0 +1,119 @@
+package mhlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAppsPrune(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	config.Provenance = NewReleaseProvenance(config, "v1")
+	apps[1].Protected = true
+
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	backend.Releases["other"] = []ReleaseRevision{{Revision: 1, Status: "DEPLOYED", Chart: "other-1.0.0"}}
+	if err := (revisionProvenances{1: {Owner: "elsewhere"}}).write(apps[0].Kube, "other", ""); err != nil {
+		t.Fatal(err)
+	}
+	backend.Releases["manual"] = []ReleaseRevision{{Revision: 1, Status: "DEPLOYED", Chart: "manual-1.0.0"}}
+	backend.Values["manual"] = []byte("replicas: 2\n")
+
+	// Releases of configured apps and of other owners are not prunable
+	prunable, results := apps.Prunable(config)
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(prunable) != 0 {
+		t.Fatalf("Unexpected prunable releases: %+v", prunable)
+	}
+
+	// Protected releases are listed, but not pruned
+	prunable, _ = apps[:1].Prunable(config)
+	if len(prunable) != 1 || prunable[0].Name != "baz" || !prunable[0].Protected {
+		t.Fatalf("Unexpected prunable releases: %+v", prunable)
+	}
+	if results := prunable.Prune(); len(results) != 0 {
+		t.Fatalf("Protected release was pruned: %+v", results)
+	}
+
+	prunable, _ = apps[1:].Prunable(config)
+	if len(prunable) != 1 || prunable[0].Name != "foo" || prunable[0].Protected {
+		t.Fatalf("Unexpected prunable releases: %+v", prunable)
+	}
+	if err := prunable.Prune().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := backend.Releases["foo"]; ok {
+		t.Fatal("Release foo was not purged")
+	}
+	if _, ok := apps[0].Kube.(*FakeKubeClient).Objects["ConfigMap foo-mh-provenance"]; ok {
+		t.Fatal("Provenance of release foo was not deleted")
+	}
+
+	// Releases are owned by the owner of the apps
+	apps[1].Owner = "elsewhere"
+	prunable, _ = apps[1:].Prunable(config)
+	if len(prunable) != 1 || prunable[0].Name != "other" {
+		t.Fatalf("Unexpected prunable releases of other owner: %+v", prunable)
+	}
+}
+
+func TestAppsPruneProtectKey(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.yaml":     testConfig,
+		"apps/foo.yaml": testAppFile,
+		"apps/bar.yaml": testAppFile,
+	})
+	defer os.RemoveAll(dir)
+
+	// Apps spelling protected as protect are refused rather than pruned
+	protect := true
+	mhConfigFile := MHConfigFile{
+		Apps:       AppConfigs{{Name: "foo"}, {Name: "bar", Alias: "baz", Protect: &protect}},
+		AppSources: testMHConfigFile.AppSources,
+	}
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	_, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), filepath.Join(dir, "main.yaml"), Selector{}, false, DefaultMHConfig)
+	if err == nil || !strings.Contains(err.Error(), "set protected instead") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestAppsPruneOtherConfig(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	config.Provenance = NewReleaseProvenance(config, "v1")
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another config outside of git, sharing the cluster, owns the releases
+	// of its own path only
+	other, otherConfig, _ := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(otherConfig.File))
+	otherConfig.Provenance = NewReleaseProvenance(otherConfig, "v1")
+	if otherConfig.Provenance.Owner != otherConfig.File || otherConfig.Provenance.Owner == config.Provenance.Owner {
+		t.Fatalf("Unexpected owners: %s and %s", config.Provenance.Owner, otherConfig.Provenance.Owner)
+	}
+	for i := range other {
+		other[i].Backend = backend
+		other[i].Kube = apps[0].Kube
+	}
+
+	prunable, results := other[:1].Prunable(otherConfig)
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(prunable) != 0 {
+		t.Fatalf("Releases of another config are prunable: %+v", prunable)
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+)
+
+// Redactor masks secrets in text and in values. Secrets are added while apps
+// are rendered, so it is safe for concurrent use.
+type Redactor struct {
+	// secrets are masked in text, longest first.
+	secrets []string
+	// values are masked where they are whole values, whatever their length.
+	values map[string]bool
+	mutex  sync.RWMutex
+}
+
+// minSecretLength is the length below which secrets are not masked in text,
+// as short values like "1" or "true" would mask unrelated text. Values are
+// masked whatever their length.
+const minSecretLength = 4
+
+// add adds all string leaves of a value as secrets. Secrets spanning multiple
+// lines are also masked line by line in text, as they may be re-indented.
+func (r *Redactor) add(value interface{}) {
+	var secrets []string
+	collectSecrets(value, &secrets)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.values == nil {
+		r.values = map[string]bool{}
+	}
+	for _, secret := range secrets {
+		r.values[secret] = true
+		if len(secret) >= minSecretLength {
+			r.secrets = append(r.secrets, secret)
+		}
+		if strings.Contains(secret, "\n") {
+			for _, line := range strings.Split(secret, "\n") {
+				if line = strings.TrimSpace(line); len(line) >= minSecretLength {
+					r.secrets = append(r.secrets, line)
+				}
+			}
+		}
+	}
+	// Replace longer secrets first, so no parts of them remain
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// collectSecrets appends the non-empty string leaves of a value to secrets.
+func collectSecrets(value interface{}, secrets *[]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			collectSecrets(child, secrets)
+		}
+	case []interface{}:
+		for _, child := range value {
+			collectSecrets(child, secrets)
+		}
+	case string:
+		if secret := strings.TrimSpace(value); secret != "" {
+			*secrets = append(*secrets, secret)
+		}
+	}
+}
+
+// Redact returns text with all secrets replaced by "***", also where they are
+// escaped as in JSON. As it is unknown where values start and end in text,
+// secrets shorter than minSecretLength are not masked.
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, secret := range r.secrets {
+		text = strings.Replace(text, secret, "***", -1)
+		if escaped, err := json.Marshal(secret); err == nil {
+			text = strings.Replace(text, string(escaped[1:len(escaped)-1]), "***", -1)
+		}
+	}
+
+	return text
+}
+
+// redactValue returns a copy of value with all string leaves that are secrets
+// replaced by "***", whatever their length, and whether any were.
+func (r *Redactor) redactValue(value interface{}) (interface{}, bool) {
+	if r == nil {
+		return value, false
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		redacted, masked := map[string]interface{}{}, false
+		for key, child := range value {
+			var childMasked bool
+			redacted[key], childMasked = r.redactValue(child)
+			masked = masked || childMasked
+		}
+		return redacted, masked
+	case []interface{}:
+		redacted, masked := make([]interface{}, len(value)), false
+		for i, child := range value {
+			var childMasked bool
+			redacted[i], childMasked = r.redactValue(child)
+			masked = masked || childMasked
+		}
+		return redacted, masked
+	case string:
+		r.mutex.RLock()
+		defer r.mutex.RUnlock()
+		if r.values[strings.TrimSpace(value)] {
+			return "***", true
+		}
+	}
+
+	return value, false
+}
+
+// redactYAML masks the string values of a YAML document that are secrets,
+// whatever their length. Documents without secrets are returned as is.
+func (r *Redactor) redactYAML(document []byte) []byte {
+	var value interface{}
+	if yaml.Unmarshal(document, &value) != nil {
+		return document
+	}
+	redacted, masked := r.redactValue(value)
+	if !masked {
+		return document
+	}
+
+	out, err := yaml.Marshal(redacted)
+	if err != nil {
+		return document
+	}
+	return out
+}
+
+// redactSetValue masks the values of a --set value, e.g. "a=b,c=d", that are
+// secrets, whatever their length.
+func (r *Redactor) redactSetValue(setValue string) string {
+	pairs := strings.Split(setValue, ",")
+	for i, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, masked := r.redactValue(parts[1]); masked {
+			pairs[i] = parts[0] + "=***"
+		}
+	}
+
+	return r.Redact(strings.Join(pairs, ","))
+}
+
+// addSensitive adds the values below value whose key path matches any of the
+// sensitive key patterns as secrets.
+//
+// Patterns are globs over dot-separated key paths, matching whole paths or
+// their trailing keys: "password" matches every key named password and
+// "*.password" every password key below another key.
+func (r *Redactor) addSensitive(value interface{}, patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+	walkLeaves(value, nil, func(keys []string, leaf interface{}) {
+		if matchesSensitiveKey(keys, patterns) {
+			r.add(leaf)
+		}
+	})
+}
+
+// maskSensitiveYAML masks the values of sensitive keys in deployed and
+// rendered YAML documents like maskSecretData does: values are "***", or
+// "*** (before)" and "*** (after)" if they changed. Documents without
+// sensitive values are returned as is, rendered may be nil.
+func maskSensitiveYAML(deployed, rendered []byte, patterns []string) ([]byte, []byte) {
+	if len(patterns) == 0 {
+		return deployed, rendered
+	}
+	var before, after map[string]interface{}
+	if yaml.Unmarshal(deployed, &before) != nil || yaml.Unmarshal(rendered, &after) != nil {
+		return deployed, rendered
+	}
+	if !maskSensitive(before, after, nil, patterns) {
+		return deployed, rendered
+	}
+
+	format := func(original []byte, document map[string]interface{}) []byte {
+		if document == nil {
+			return original
+		}
+		out, err := yaml.Marshal(document)
+		if err != nil {
+			return original
+		}
+		return out
+	}
+
+	return format(deployed, before), format(rendered, after)
+}
+
+// maskSensitive masks the leaves below the key path keys of the before and
+// after values whose key path matches any of the sensitive key patterns, and
+// returns true if any were masked.
+func maskSensitive(before, after map[string]interface{}, keys []string, patterns []string) bool {
+	masked := false
+	mask := func(values map[string]interface{}, key, replacement string) {
+		if value, ok := values[key]; ok && value != nil {
+			values[key] = replacement
+			masked = true
+		}
+	}
+
+	for key := range union(before, after) {
+		path := append(append([]string{}, keys...), key)
+		beforeValue, beforeMap := before[key].(map[string]interface{})
+		afterValue, afterMap := after[key].(map[string]interface{})
+		if beforeMap || afterMap {
+			if maskSensitive(beforeValue, afterValue, path, patterns) {
+				masked = true
+			}
+		}
+		if !matchesSensitiveKey(path, patterns) {
+			continue
+		}
+
+		_, inBefore := before[key]
+		_, inAfter := after[key]
+		if inBefore && inAfter && !beforeMap && !afterMap && !reflect.DeepEqual(before[key], after[key]) {
+			mask(before, key, "*** (before)")
+			mask(after, key, "*** (after)")
+			continue
+		}
+		if !beforeMap {
+			mask(before, key, "***")
+		}
+		if !afterMap {
+			mask(after, key, "***")
+		}
+	}
+
+	return masked
+}
+
+// union returns the keys of all maps.
+func union(maps ...map[string]interface{}) map[string]bool {
+	keys := map[string]bool{}
+	for _, m := range maps {
+		for key := range m {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// walkLeaves calls fn with the key path of each leaf below value. List items
+// are leaves as a whole.
+func walkLeaves(value interface{}, keys []string, fn func(keys []string, leaf interface{})) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		if len(keys) > 0 {
+			fn(keys, value)
+		}
+		return
+	}
+	for key, child := range m {
+		walkLeaves(child, append(append([]string{}, keys...), key), fn)
+	}
+}
+
+// matchesSensitiveKey returns true if any pattern matches a key path or its
+// trailing keys.
+func matchesSensitiveKey(keys []string, patterns []string) bool {
+	for _, pattern := range patterns {
+		segments := len(strings.Split(pattern, "."))
+		if segments > len(keys) {
+			continue
+		}
+
+		// Match the pattern against the trailing keys, with "/" separating
+		// them so "*" does not span keys.
+		trailing := strings.Join(keys[len(keys)-segments:], "/")
+		if matched, _ := path.Match(strings.Replace(pattern, ".", "/", -1), trailing); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RedactingFormatter is a logrus Formatter masking secrets in the output of
+// another Formatter.
+type RedactingFormatter struct {
+	Formatter logrus.Formatter
+	Redactor  *Redactor
+}
+
+// Format formats an entry with the wrapped Formatter and masks secrets.
+func (f *RedactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	out, err := f.Formatter.Format(entry)
+	if err != nil {
+		return out, err
+	}
+
+	return []byte(f.Redactor.Redact(string(out))), nil
+}
@@ -0,0 +1,179 @@
+package mhlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMatchesSensitiveKey(t *testing.T) {
+	patterns := []string{"*.password", "token", "tls.*"}
+	for keys, expected := range map[string]bool{
+		"db.password":     true,
+		"a.db.password":   true,
+		"password":        false,
+		"auth.token":      true,
+		"token":           true,
+		"ingress.tls.key": true,
+		"tls":             false,
+		"db.user":         false,
+	} {
+		if matched := matchesSensitiveKey(strings.Split(keys, "."), patterns); matched != expected {
+			t.Errorf("Key %s matched: %v, expected: %v", keys, matched, expected)
+		}
+	}
+}
+
+func TestRedactApp(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"main.yaml": `
+foo:
+  password: hunter2
+  apiKey: s3cr3t
+  user: admin
+`,
+		"apps/foo.yaml": `
+chart: stable/foo
+version: 0.1.0
+db:
+  user: {{ $app.user }}
+  password: {{ $app.password }}
+apiKey: {{ secret $app.apiKey }}
+`,
+	})
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "main.yaml")
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	mhConfigFile := MHConfigFile{
+		Apps:       AppConfigs{{Name: "foo"}},
+		AppSources: AppSourceConfigs{{Name: "apps", Kind: "configPath", Source: "apps"}},
+	}
+	mhConfig := DefaultMHConfig
+	mhConfig.SensitiveKeys = []string{"*.password"}
+	apps, err := mhConfigFile.EffectiveApps(logger.WithField("command", "test"), configFile, Selector{}, false, mhConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := (*apps)[0]
+
+	config, err := RenderConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := app.Render(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Helm gets the actual values
+	if !strings.Contains(string(rendered.Overrides), "password: hunter2") ||
+		!strings.Contains(string(rendered.Overrides), "apiKey: s3cr3t") {
+		t.Fatalf("Unexpected overrides:\n%s", rendered.Overrides)
+	}
+
+	printable := app.printable(config, rendered.Overrides)
+	if strings.Contains(printable, "hunter2") || strings.Contains(printable, "s3cr3t") ||
+		!strings.Contains(printable, "password: '***'") ||
+		!strings.Contains(printable, "user: admin") {
+		t.Fatalf("Unexpected printable overrides:\n%s", printable)
+	}
+
+	app.PrintSecrets = true
+	if printable := app.printable(config, rendered.Overrides); !strings.Contains(printable, "hunter2") {
+		t.Fatalf("Secrets are masked despite printSecrets:\n%s", printable)
+	}
+
+	// Logs are masked as well
+	var out bytes.Buffer
+	logger.Out = &out
+	logger.Formatter = &RedactingFormatter{Formatter: new(logrus.JSONFormatter), Redactor: config.Redactor}
+	logger.WithField("error", "Invalid password \"hunter2\"").Error("Failed")
+	if strings.Contains(out.String(), "hunter2") || !strings.Contains(out.String(), "Invalid password \\\"***\\\"") {
+		t.Fatalf("Unexpected log: %s", out.String())
+	}
+}
+
+func TestMaskSecretData(t *testing.T) {
+	deployed := map[string]string{
+		"Secret foo": "kind: Secret\ndata:\n  same: YQ==\n  changed: Yg==\n  removed: Yw==\n",
+	}
+	rendered := map[string]string{
+		"Secret foo":    "kind: Secret\ndata:\n  same: YQ==\n  changed: ZA==\n  added: ZQ==\n",
+		"ConfigMap foo": "kind: ConfigMap\ndata:\n  same: a\n",
+	}
+	maskSecretData(deployed, rendered)
+
+	for _, content := range []string{deployed["Secret foo"], rendered["Secret foo"]} {
+		if strings.Contains(content, "==") {
+			t.Fatalf("Secret data is not masked:\n%s", content)
+		}
+	}
+	if !strings.Contains(deployed["Secret foo"], "changed: '*** (before)'") ||
+		!strings.Contains(rendered["Secret foo"], "changed: '*** (after)'") ||
+		!strings.Contains(rendered["Secret foo"], "added: '***'") {
+		t.Fatalf("Unexpected masked secrets:\n%s\n%s", deployed["Secret foo"], rendered["Secret foo"])
+	}
+	if rendered["ConfigMap foo"] != "kind: ConfigMap\ndata:\n  same: a\n" {
+		t.Fatalf("ConfigMap was changed:\n%s", rendered["ConfigMap foo"])
+	}
+}
+
+func TestRedactShortSecrets(t *testing.T) {
+	redactor := &Redactor{}
+	redactor.addSensitive(map[string]interface{}{
+		"db": map[string]interface{}{"password": 1, "enabled": true, "user": "ab", "host": "db.internal"},
+	}, []string{"db.*"})
+
+	// Numbers, bools and short strings would mask unrelated text
+	text := "replicas: 1\nenabled: true\nlabel: abc\nhost: db.internal\n"
+	if redacted := redactor.Redact(text); redacted != "replicas: 1\nenabled: true\nlabel: abc\nhost: ***\n" {
+		t.Fatalf("Unexpected redacted text:\n%s", redacted)
+	}
+}
+
+func TestRedactShortSecretValues(t *testing.T) {
+	redactor := &Redactor{}
+	redactor.add(map[string]interface{}{"pin": "12"})
+
+	// Short secrets are masked where they are whole values, but not in text
+	document := []byte("pin: \"12\"\nreplicas: 12\nlabel: a12\n")
+	if redacted := string(redactor.redactYAML(document)); redacted != "label: a12\npin: '***'\nreplicas: 12\n" {
+		t.Fatalf("Unexpected redacted values:\n%s", redacted)
+	}
+	if redacted := redactor.redactSetValue("pin=12,replicas=3"); redacted != "pin=***,replicas=3" {
+		t.Fatalf("Unexpected redacted --set value: %s", redacted)
+	}
+	if redacted := redactor.Redact("pin: 12"); redacted != "pin: 12" {
+		t.Fatalf("Unexpected redacted text: %s", redacted)
+	}
+
+	// Documents without secrets are not re-formatted
+	document = []byte("# Comment\nreplicas: 12\n")
+	if redacted := redactor.redactYAML(document); string(redacted) != string(document) {
+		t.Fatalf("Unexpected redacted values:\n%s", redacted)
+	}
+}
+
+func TestMaskSensitiveYAML(t *testing.T) {
+	deployed := []byte("db:\n  password: 1\n  port: 5432\n  user: admin\nreplicas: 1\n")
+	rendered := []byte("db:\n  password: 2\n  port: 5432\n  user: admin\nreplicas: 1\n")
+	deployed, rendered = maskSensitiveYAML(deployed, rendered, []string{"password", "port"})
+
+	if string(deployed) != "db:\n  password: '*** (before)'\n  port: '***'\n  user: admin\nreplicas: 1\n" ||
+		string(rendered) != "db:\n  password: '*** (after)'\n  port: '***'\n  user: admin\nreplicas: 1\n" {
+		t.Fatalf("Unexpected masked values:\n%s\n%s", deployed, rendered)
+	}
+
+	// Documents without sensitive values are not re-formatted
+	values := []byte("# Comment\nreplicas: 1\n")
+	if masked, _ := maskSensitiveYAML(values, nil, []string{"password"}); string(masked) != string(values) {
+		t.Fatalf("Unexpected masked values:\n%s", masked)
+	}
+}
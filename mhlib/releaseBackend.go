@@ -0,0 +1,112 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReleaseBackend is what an App uses to operate on its Helm release. All
+// methods return the arguments of the command they ran, if any, to be logged
+// on failure.
+type ReleaseBackend interface {
+	// Upgrade upgrades a release, optionally installing it if it does not
+	// exist yet.
+	Upgrade(options UpgradeOptions) (*[]interface{}, error)
+	// Delete deletes a release, optionally purging it.
+	Delete(release, namespace string, purge bool) (*[]interface{}, error)
+	// Status prints the status of a release.
+	Status(release, namespace string) (*[]interface{}, error)
+	// DependencyUpdate updates the dependencies of a chart on disk.
+	DependencyUpdate(chart string) (*[]interface{}, error)
+	// History returns the revisions of a release, oldest first.
+	History(release, namespace string) ([]ReleaseRevision, *[]interface{}, error)
+	// Rollback rolls a release back to a given revision.
+	Rollback(release, namespace string, revision int) (*[]interface{}, error)
+	// GetManifest returns the manifest of the deployed release.
+	GetManifest(release, namespace string) (string, *[]interface{}, error)
+	// GetValues returns the user-supplied values of a revision of the release,
+	// of the deployed one if revision is 0.
+	GetValues(release, namespace string, revision int) ([]byte, *[]interface{}, error)
+	// RenderManifest returns the manifest an upgrade would deploy without
+	// deploying it.
+	RenderManifest(options UpgradeOptions) (string, *[]interface{}, error)
+	// List returns the releases in all namespaces.
+	List() ([]Release, *[]interface{}, error)
+}
+
+// outputSetter is implemented by ReleaseBackends printing Helm's output, so
+// that Apps running concurrently can print it one App after the other.
+type outputSetter interface {
+	setOutput(out io.Writer)
+}
+
+// ErrReleaseNotFound is returned by ReleaseBackends for releases that do not
+// exist.
+var ErrReleaseNotFound = errors.New("Release not found")
+
+// NewReleaseBackend returns the ReleaseBackend for the Helm version configured
+// in a MHConfig, operating on the cluster selected by its context mode.
+func NewReleaseBackend(logger *logrus.Entry, config MHConfig) (ReleaseBackend, error) {
+	kube, err := NewKubeOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.HelmVersion {
+	case 0, 2:
+		return &HelmV2Backend{kube: kube}, nil
+	case 3:
+		return &HelmV3Backend{log: logger, kube: kube}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported helmVersion: %d", config.HelmVersion)
+	}
+}
+
+// UpgradeOptions are the options to ReleaseBackend.Upgrade.
+type UpgradeOptions struct {
+	Release      string
+	Chart        string
+	Version      string
+	Namespace    string
+	Values       []byte
+	DryRun       bool
+	Force        bool
+	Install      bool
+	RecreatePods bool
+}
+
+// Release is a release in a cluster. Helm versions differ in the case of the
+// keys they list releases with, which JSON decoding ignores.
+type Release struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Chart     string `json:"chart"`
+}
+
+// ReleaseRevision is a single entry of a release's history.
+type ReleaseRevision struct {
+	Revision    int    `json:"revision"`
+	Updated     string `json:"updated"`
+	Status      string `json:"status"`
+	Chart       string `json:"chart"`
+	AppVersion  string `json:"app_version"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,160 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// RenderedConfig is a mh configuration file, composed and self-rendered once
+// per run. It is shared by all apps rendered during the run, so gomplate
+// datasources are fetched once and every app sees the same data. It is not
+// modified after RenderConfig returns, except for its Redactor collecting the
+// sensitive values of apps as they are rendered.
+type RenderedConfig struct {
+	// File is the path of the mh configuration file.
+	File string
+	// Layers are the files the mh configuration is composed of.
+	Layers []ConfigLayer
+	// Passes are the composed mh configuration followed by the result of
+	// each self-render pass that changed it.
+	Passes []string
+	// Contents is the self-rendered mh configuration, with secrets still
+	// encrypted.
+	Contents string
+	// Redactor masks the decrypted secrets and sensitive values.
+	Redactor *Redactor
+	// Provenance is recorded in the releases of apps applied with the
+	// configuration, if set.
+	Provenance *ReleaseProvenance
+
+	values chartutil.Values
+	// secretSources maps the paths of decrypted values to where they were
+	// decrypted from.
+	secretSources map[string]string
+}
+
+// RenderConfig loads a mh configuration file with its includes and overlays,
+// self-renders it and decrypts its secrets.
+func RenderConfig(configFile string) (*RenderedConfig, error) {
+	layers, err := LoadConfigLayers(configFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := composeConfigLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Self-render the main.yaml with gomplate functions and datasources
+	//   This does not apply to the app.yaml files.
+	passes, err := selfRenderPasses(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to selfRender configFile %v: %v", configFile, err)
+	}
+	contents := passes[len(passes)-1]
+
+	values, err := chartutil.ReadValues([]byte(contents))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load values from configFile: %v", err)
+	}
+
+	config := &RenderedConfig{
+		File:          configFile,
+		Layers:        layers,
+		Passes:        passes,
+		Contents:      contents,
+		Redactor:      &Redactor{},
+		secretSources: map[string]string{},
+	}
+	if err := config.decrypt(values); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// decrypt decrypts the encrypted values and merges the encrypted files of the
+// configuration into values, remembering the secrets.
+func (c *RenderedConfig) decrypt(values chartutil.Values) error {
+	var encryption struct {
+		Encryption EncryptionConfig `json:"encryption"`
+	}
+	if err := yaml.Unmarshal([]byte(c.Contents), &encryption); err != nil {
+		return fmt.Errorf("Failed to load encryption configuration: %v", err)
+	}
+
+	d := newDecrypter(encryption.Encryption, filepath.Dir(c.File))
+	defer d.close()
+
+	decrypted, err := d.decryptValues(map[string]interface{}(values), nil)
+	if err != nil {
+		return err
+	}
+	for key, plaintexts := range decrypted {
+		c.secretSources[key] = "decrypted"
+		for _, plaintext := range plaintexts {
+			c.Redactor.add(plaintext)
+		}
+	}
+
+	merged := map[string]interface{}(values)
+	for _, file := range encryption.Encryption.Files {
+		secrets, err := d.decryptFile(file)
+		if err != nil {
+			return err
+		}
+		for _, path := range leafPaths(secrets, nil) {
+			c.secretSources[valueKey(path)] = file + " (encrypted)"
+		}
+		c.Redactor.add(secrets)
+		merged = mergeConfigValues(merged, secrets).(map[string]interface{})
+	}
+	c.values = merged
+
+	return nil
+}
+
+// Values returns the values of the RenderedConfig, with secrets decrypted.
+// Each call returns a new copy, so callers may modify it.
+func (c *RenderedConfig) Values() chartutil.Values {
+	return chartutil.Values(copyValue(map[string]interface{}(c.values)).(map[string]interface{}))
+}
+
+// copyValue returns a deep copy of nested maps and lists.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			copied[key] = copyValue(child)
+		}
+		return copied
+	case chartutil.Values:
+		return copyValue(map[string]interface{}(value))
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, child := range value {
+			copied[i] = copyValue(child)
+		}
+		return copied
+	}
+
+	return value
+}
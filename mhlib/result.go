@@ -0,0 +1,168 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of running an action on an App.
+type Result struct {
+	App      string
+	Context  string
+	Action   string
+	Cmd      []interface{}
+	Duration time.Duration
+	// Error is the reason the action failed or was skipped.
+	Error   error
+	Skipped bool
+	// Rollback is the outcome of rolling back a failed apply, if any.
+	Rollback string
+}
+
+// SkipError is returned by actions skipping an App on purpose, e.g. a
+// protected App that is not destroyed. Unlike Apps skipped after a failure,
+// such Apps do not fail the Results.
+type SkipError struct {
+	// Reason is why the App was skipped, e.g. "protected".
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return e.Reason
+}
+
+// failed returns true if the action failed or was skipped after a failure.
+func (r *Result) failed() bool {
+	_, skipped := r.Error.(*SkipError)
+	return r.Error != nil && !skipped
+}
+
+// Status returns "ok", "failed" or "skipped".
+func (r *Result) Status() string {
+	if r.Skipped {
+		return "skipped"
+	}
+	if r.Error != nil {
+		return "failed"
+	}
+
+	return "ok"
+}
+
+// Results are the Results of running an action on Apps, in the order they
+// were run.
+type Results []Result
+
+// Failed returns true if any action failed or was skipped after a failure.
+func (r Results) Failed() bool {
+	for _, result := range r {
+		if result.failed() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Err returns the aggregated Errors of all failed actions or nil if none
+// failed.
+func (r Results) Err() error {
+	var errs Errors
+	for _, result := range r {
+		if result.Error != nil && !result.Skipped {
+			errs = append(errs, fmt.Errorf("%s: %v", result.App, result.Error))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// PrintTable prints the Results as a human readable table.
+func (r Results) PrintTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTEXT\tAPP\tACTION\tSTATUS\tROLLBACK\tDURATION\tERROR\tCOMMAND")
+	for _, result := range r {
+		// The rollback outcome has a column of its own
+		var errorMessage string
+		if rollbackErr, ok := result.Error.(*RollbackError); ok {
+			errorMessage = rollbackErr.Err.Error()
+		} else if result.Error != nil {
+			errorMessage = result.Error.Error()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.Context,
+			result.App,
+			result.Action,
+			result.Status(),
+			result.Rollback,
+			result.Duration.Round(time.Millisecond),
+			errorMessage,
+			strings.TrimSpace(fmt.Sprintln(result.Cmd...)),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// PrintJSON prints the Results as a JSON document.
+func (r Results) PrintJSON(w io.Writer) error {
+	type jsonResult struct {
+		App      string        `json:"app"`
+		Context  string        `json:"context"`
+		Action   string        `json:"action"`
+		Status   string        `json:"status"`
+		Cmd      []interface{} `json:"cmd"`
+		Duration float64       `json:"durationSeconds"`
+		Error    string        `json:"error,omitempty"`
+		Rollback string        `json:"rollback,omitempty"`
+	}
+	doc := struct {
+		Failed  bool         `json:"failed"`
+		Results []jsonResult `json:"results"`
+	}{
+		Failed:  r.Failed(),
+		Results: []jsonResult{},
+	}
+
+	for _, result := range r {
+		jr := jsonResult{
+			App:      result.App,
+			Context:  result.Context,
+			Action:   result.Action,
+			Status:   result.Status(),
+			Cmd:      result.Cmd,
+			Duration: result.Duration.Seconds(),
+			Rollback: result.Rollback,
+		}
+		if result.Error != nil {
+			jr.Error = result.Error.Error()
+		}
+		doc.Results = append(doc.Results, jr)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
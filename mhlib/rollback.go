@@ -0,0 +1,96 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RollbackError is the error of an App whose failed apply was rolled back.
+type RollbackError struct {
+	Err error
+	// Outcome describes the rollback, e.g. "rolled back to revision 3".
+	Outcome string
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%v (%s)", e.Err, e.Outcome)
+}
+
+// deployedRevision returns the latest deployed revision of the App's release,
+// or nil if the release does not exist yet.
+func (a *App) deployedRevision() (*ReleaseRevision, error) {
+	revisions, _, err := a.Backend.History(a.ID, a.Namespace)
+	if err == ErrReleaseNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get release history: %v", err)
+	}
+
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if strings.EqualFold(revisions[i].Status, "deployed") {
+			return &revisions[i], nil
+		}
+	}
+
+	return &ReleaseRevision{}, nil
+}
+
+// rollback rolls the App's release back to the previously deployed revision
+// after an apply failed with err, or deletes it if it was installed first.
+// Returns a RollbackError describing the outcome.
+func (a *App) rollback(previous *ReleaseRevision, err error) error {
+	rollbackErr := &RollbackError{Err: err}
+
+	switch {
+	case previous == nil:
+		a.log.Warn("Deleting first install of app after failure")
+		_, deleteErr := a.Backend.Delete(a.ID, a.Namespace, true)
+		switch deleteErr {
+		case nil:
+			rollbackErr.Outcome = "deleted first install"
+			if err := forgetProvenance(a.Kube, a.ID, a.Namespace); err != nil {
+				a.log.WithField("error", err).Warn("Failed to delete provenance of first install")
+			}
+		case ErrReleaseNotFound:
+			rollbackErr.Outcome = "first install left nothing to delete"
+		default:
+			rollbackErr.Outcome = fmt.Sprintf("deleting first install failed: %v", deleteErr)
+		}
+	case previous.Revision == 0:
+		rollbackErr.Outcome = "not rolled back, no revision was deployed before"
+	default:
+		a.log.WithField("revision", previous.Revision).Warn("Rolling back app after failure")
+		if _, err := a.Backend.Rollback(a.ID, a.Namespace, previous.Revision); err != nil {
+			rollbackErr.Outcome = fmt.Sprintf("rollback to revision %d failed: %v", previous.Revision, err)
+		} else {
+			rollbackErr.Outcome = fmt.Sprintf("rolled back to revision %d", previous.Revision)
+			if err := a.recordRollbackProvenance(previous.Revision); err != nil {
+				a.log.WithField("error", err).Warn("Failed to record provenance of rollback")
+			}
+		}
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"error":   err,
+		"outcome": rollbackErr.Outcome,
+	}).Warn("Rolled back failed apply")
+
+	return rollbackErr
+}
@@ -0,0 +1,90 @@
+package mhlib
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppsApplyRollback(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	for i := range apps {
+		apps[i].RollbackOnFailure = true
+	}
+
+	// A failed upgrade is rolled back to the deployed revision
+	if err := apps[:1].Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	backend.Errors["Upgrade foo"] = errors.New("boom")
+	results := apps[:1].Apply(config, RunOptions{})
+	if results[0].Rollback != "rolled back to revision 1" || results[0].Error.Error() != "boom (rolled back to revision 1)" {
+		t.Fatalf("Unexpected result: %+v", results[0])
+	}
+	if revisions := backend.Releases["foo"]; len(revisions) != 2 || revisions[1].Description != "Rollback to 1" {
+		t.Fatalf("Unexpected revisions: %+v", revisions)
+	}
+
+	// A failed first install is deleted, the FakeBackend did not record it
+	backend.Errors["Upgrade baz"] = errors.New("boom")
+	results = apps[1:].Apply(config, RunOptions{})
+	if results[0].Rollback != "first install left nothing to delete" {
+		t.Fatalf("Unexpected result: %+v", results[0])
+	}
+
+	expected := []string{"History foo", "Upgrade foo", "History foo", "Upgrade foo", "Rollback foo", "History baz", "Upgrade baz", "Delete baz"}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, expected) {
+		t.Fatalf("\nActual: %v\nExpected: %v\n", methods, expected)
+	}
+}
+
+func TestAppsApplyRollbackFirstInstall(t *testing.T) {
+	defer func(interval time.Duration) { waitInterval = interval }(waitInterval)
+	waitInterval = time.Millisecond
+
+	apps, config, kube := newWaitingTestApps(t, "10ms")
+	defer os.RemoveAll(filepath.Dir(config.File))
+	backend := apps[0].Backend.(*FakeBackend)
+	apps[0].RollbackOnFailure = true
+
+	// baz is installed but does not become ready, so it is deleted
+	kube.Objects["Deployment apps/web"] = []string{deploymentState(1)}
+	results := apps.Apply(config, RunOptions{})
+	if results[0].Status() != "failed" || results[0].Rollback != "deleted first install" {
+		t.Fatalf("Unexpected result: %+v", results[0])
+	}
+	if _, ok := backend.Releases["baz"]; ok {
+		t.Fatalf("First install was not deleted: %+v", backend.Releases["baz"])
+	}
+
+	var out bytes.Buffer
+	if err := results.PrintTable(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if fields := strings.Fields(lines[1]); len(fields) < 8 || strings.Join(fields[3:7], " ") != "failed deleted first install" {
+		t.Fatalf("Unexpected table:\n%s", out.String())
+	}
+}
+
+func TestAppsApplyNoRollback(t *testing.T) {
+	apps, config, backend := newTestApps(t, testMHConfigFile)
+	defer os.RemoveAll(filepath.Dir(config.File))
+	apps[0].RollbackOnFailure = true
+	apps[0].NoRollback = true
+
+	backend.Errors["Upgrade foo"] = errors.New("boom")
+	results := apps[:1].Apply(config, RunOptions{})
+	if results[0].Rollback != "" || results[0].Error.Error() != "boom" {
+		t.Fatalf("Unexpected result: %+v", results[0])
+	}
+	if methods := backend.Methods(); !reflect.DeepEqual(methods, []string{"Upgrade foo"}) {
+		t.Fatalf("App opting out was rolled back: %v", methods)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigSchema returns the JSON Schema of mh configuration files, generated
+// from MHConfigFile. Top-level keys other than those of MHConfigFile are
+// values for apps and may be anything.
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(MHConfigFile{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "mh configuration"
+	schema["additionalProperties"] = true
+
+	return schema
+}
+
+// typeSchema returns the JSON Schema of a type. Struct fields are named by
+// their yaml tag; fields without one are not configurable and left out.
+// Embedded structs without a yaml tag are inlined.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		addStructProperties(t, properties)
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// addStructProperties adds the schemas of a struct's fields to properties.
+func addStructProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addStructProperties(field.Type, properties)
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+
+		properties[name] = typeSchema(field.Type)
+	}
+}
+
+// ValidationError is a value that does not match a JSON Schema.
+type ValidationError struct {
+	// Path is the path to the value, like "apps[2].namespace".
+	Path string
+	// Location is the file and line of the value, if known.
+	Location string
+	Message  string
+	// Unknown is true if the value is not defined by the schema.
+	Unknown  bool
+	elements []string
+}
+
+func (e ValidationError) Error() string {
+	prefix := e.Path
+	if e.Location != "" {
+		prefix = e.Location + ": " + e.Path
+	}
+	if prefix == "" {
+		return e.Message
+	}
+
+	return prefix + ": " + e.Message
+}
+
+// ValidationErrors are all ValidationErrors of a validation.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// validateSchema validates a value, as unmarshalled from YAML or JSON, against
+// a JSON Schema. It supports the subset of JSON Schema mh generates and
+// charts commonly use: type, properties, additionalProperties, required,
+// items, enum, minimum and maximum.
+func validateSchema(schema map[string]interface{}, value interface{}, path []string) ValidationErrors {
+	var errs ValidationErrors
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Path: formatPath(path), Message: fmt.Sprintf(format, args...), elements: path})
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesAnyType(value, types) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+		return errs
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(normalizeNumber(allowed), normalizeNumber(value)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	if number, ok := normalizeNumber(value).(float64); ok {
+		if minimum, ok := normalizeNumber(schema["minimum"]).(float64); ok && number < minimum {
+			fail("must be at least %v", schema["minimum"])
+		}
+		if maximum, ok := normalizeNumber(schema["maximum"]).(float64); ok && number > maximum {
+			fail("must be at most %v", schema["maximum"])
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok {
+					if _, ok := value[name]; !ok {
+						fail("missing required key %s", name)
+					}
+				}
+			}
+		}
+
+		var keys []string
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := append(append([]string{}, path...), key)
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(property, value[key], child)...)
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					message := "unknown key " + key
+					if suggestion := suggestKey(key, properties); suggestion != "" {
+						message += fmt.Sprintf(" (did you mean %s?)", suggestion)
+					}
+					errs = append(errs, ValidationError{Path: formatPath(child), Message: message, Unknown: true, elements: child})
+				}
+			case map[string]interface{}:
+				errs = append(errs, validateSchema(additional, value[key], child)...)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				errs = append(errs, validateSchema(items, item, append(append([]string{}, path...), fmt.Sprintf("[%d]", i)))...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// schemaTypes returns the types a JSON Schema "type" allows.
+func schemaTypes(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var types []string
+		for _, t := range value {
+			if t, ok := t.(string); ok {
+				types = append(types, t)
+			}
+		}
+		return types
+	}
+
+	return nil
+}
+
+// matchesAnyType returns true if a value is of any of the JSON Schema types.
+func matchesAnyType(value interface{}, types []string) bool {
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonType returns the JSON Schema type of a value. Numbers without a
+// fraction are integers.
+func jsonType(value interface{}) string {
+	switch value := normalizeNumber(value).(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+// normalizeNumber returns numbers as float64 and other values as they are.
+func normalizeNumber(value interface{}) interface{} {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	}
+
+	return value
+}
+
+// suggestKey returns a property that differs from an unknown key only in
+// case, or an empty string.
+func suggestKey(key string, properties map[string]interface{}) string {
+	for property := range properties {
+		if strings.EqualFold(property, key) {
+			return property
+		}
+	}
+
+	return ""
+}
+
+// formatPath formats a path like "apps[2].namespace".
+func formatPath(path []string) string {
+	var formatted string
+	for _, element := range path {
+		if _, ok := pathIndex(element); ok || formatted == "" {
+			formatted += element
+		} else {
+			formatted += "." + element
+		}
+	}
+
+	return formatted
+}
+
+// pathIndex returns the index of a path element like "[2]".
+func pathIndex(element string) (int, bool) {
+	var index int
+	if !strings.HasPrefix(element, "[") || !strings.HasSuffix(element, "]") {
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(element, "[%d]", &index); err != nil {
+		return 0, false
+	}
+
+	return index, true
+}
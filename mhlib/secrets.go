@@ -0,0 +1,243 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/codeskyblue/go-sh"
+	"github.com/ghodss/yaml"
+)
+
+// EncryptionConfig defines how mh decrypts secrets in its configuration.
+//
+// Values of the form "ENC[age,<base64>]" or "ENC[pgp,<base64>]" anywhere in the
+// configuration are decrypted with the age identity or PGP key file. Files
+// are values files encrypted as a whole with age or PGP, merged on top of the
+// configuration. Relative paths are relative to the mh configuration file.
+//
+// Secrets are decrypted in memory after self-rendering, so they are available
+// to app files but not to self-render templates.
+type EncryptionConfig struct {
+	AgeIdentity string   `yaml:"ageIdentity"`
+	Files       []string `yaml:"files"`
+	PGPKey      string   `yaml:"pgpKey"`
+}
+
+// encryptedValue matches encrypted values in the mh configuration.
+var encryptedValue = regexp.MustCompile(`^ENC\[(age|pgp),([A-Za-z0-9+/=\s]+)\]$`)
+
+// decrypter decrypts secrets with local key files by running the age and gpg
+// CLIs, so it works offline.
+type decrypter struct {
+	config    EncryptionConfig
+	dir       string
+	gnupgHome string
+}
+
+// newDecrypter returns a decrypter resolving relative paths against dir.
+func newDecrypter(config EncryptionConfig, dir string) *decrypter {
+	return &decrypter{config: config, dir: dir}
+}
+
+// close removes the temporary GnuPG home, if one was created.
+func (d *decrypter) close() {
+	if d.gnupgHome != "" {
+		os.RemoveAll(d.gnupgHome)
+	}
+}
+
+// path resolves a configured path, expanding "~/".
+func (d *decrypter) path(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(d.dir, path)
+	}
+
+	return path
+}
+
+// decrypt decrypts ciphertext of kind "age" or "pgp".
+func (d *decrypter) decrypt(kind string, ciphertext []byte) ([]byte, error) {
+	switch kind {
+	case "age":
+		if d.config.AgeIdentity == "" {
+			return nil, fmt.Errorf("Missing encryption.ageIdentity to decrypt age secrets")
+		}
+		return secretOutput(ciphertext, "age", "--decrypt", "--identity", d.path(d.config.AgeIdentity))
+	case "pgp":
+		if d.config.PGPKey == "" {
+			return nil, fmt.Errorf("Missing encryption.pgpKey to decrypt PGP secrets")
+		}
+
+		// Import the key into a temporary GnuPG home once, to not depend on
+		// or modify the user's keyring.
+		if d.gnupgHome == "" {
+			home, err := ioutil.TempDir("", "mh-gnupg")
+			if err != nil {
+				return nil, err
+			}
+			d.gnupgHome = home
+			if _, err := secretOutput(nil, "gpg", "--homedir", home, "--batch", "--quiet", "--import", d.path(d.config.PGPKey)); err != nil {
+				return nil, fmt.Errorf("Failed to import PGP key: %v", err)
+			}
+		}
+		return secretOutput(ciphertext, "gpg", "--homedir", d.gnupgHome, "--batch", "--quiet", "--decrypt")
+	}
+
+	return nil, fmt.Errorf("Unknown kind of encryption: %s", kind)
+}
+
+// decryptFile decrypts a values file encrypted with age or PGP, detecting
+// which by its contents.
+func (d *decrypter) decryptFile(file string) (map[string]interface{}, error) {
+	ciphertext, err := ioutil.ReadFile(d.path(file))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read secrets file: %v", err)
+	}
+
+	kind := "pgp"
+	if bytes.HasPrefix(ciphertext, []byte("age-encryption.org/")) || bytes.HasPrefix(ciphertext, []byte("-----BEGIN AGE ENCRYPTED FILE-----")) {
+		kind = "age"
+	}
+
+	plaintext, err := d.decrypt(kind, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt secrets file %s: %v", file, err)
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("Failed to parse secrets file %s: %v", file, err)
+	}
+
+	return values, nil
+}
+
+// decryptValues replaces all encrypted values below value with their
+// plaintext and returns the plaintexts by the key explain uses for them.
+func (d *decrypter) decryptValues(value interface{}, path []string) (map[string][]string, error) {
+	decrypted := map[string][]string{}
+
+	// decryptChild decrypts a child of value, replacing it if it is an
+	// encrypted value and descending into it otherwise
+	decryptChild := func(child interface{}, childPath []string, replace func(string)) error {
+		plaintext, ok, err := d.decryptValue(child, childPath)
+		if err != nil {
+			return err
+		}
+		if ok {
+			replace(plaintext)
+			key := valueKey(childPath)
+			decrypted[key] = append(decrypted[key], plaintext)
+			return nil
+		}
+
+		children, err := d.decryptValues(child, childPath)
+		if err != nil {
+			return err
+		}
+		for key, plaintexts := range children {
+			decrypted[key] = append(decrypted[key], plaintexts...)
+		}
+		return nil
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			key := key
+			childPath := append(append([]string{}, path...), key)
+			if err := decryptChild(child, childPath, func(plaintext string) { value[key] = plaintext }); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			i := i
+			childPath := append(append([]string{}, path...), fmt.Sprintf("[%d]", i))
+			if err := decryptChild(child, childPath, func(plaintext string) { value[i] = plaintext }); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return decrypted, nil
+}
+
+// decryptValue returns the plaintext of value at path if it is an encrypted
+// value.
+func (d *decrypter) decryptValue(value interface{}, path []string) (string, bool, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", false, nil
+	}
+	match := encryptedValue.FindStringSubmatch(s)
+	if match == nil {
+		return "", false, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(match[2]), ""))
+	if err != nil {
+		return "", false, fmt.Errorf("Invalid encrypted value at %s: %v", formatPath(path), err)
+	}
+	plaintext, err := d.decrypt(match[1], ciphertext)
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to decrypt value at %s: %v", formatPath(path), err)
+	}
+
+	return string(plaintext), true, nil
+}
+
+// valueKey returns the key explain uses for the value at path, like
+// ".foo.password". Lists are explained as a whole, so their items have the
+// key of the list.
+func valueKey(path []string) string {
+	for i, element := range path {
+		if _, ok := pathIndex(element); ok {
+			path = path[:i]
+			break
+		}
+	}
+
+	return "." + strings.Join(path, ".")
+}
+
+// secretOutput runs a command with input and returns its output. Errors contain
+// the command's error output.
+func secretOutput(input []byte, name string, args ...interface{}) ([]byte, error) {
+	var stderr bytes.Buffer
+	session := sh.NewSession()
+	session.Stderr = &stderr
+	if input != nil {
+		session.SetInput(string(input))
+	}
+
+	out, err := session.Command(name, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package mhlib
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestPGPKey creates an unprotected PGP key, writes it to dir/key.asc and
+// returns a function encrypting plaintext for it.
+func newTestPGPKey(t *testing.T, dir string) func(plaintext string) []byte {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	home := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+	gpg := func(input string, args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--quiet", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)...)
+		cmd.Stdin = strings.NewReader(input)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %v: %v", args, err)
+		}
+		return out
+	}
+
+	gpg("", "--quick-gen-key", "mh-test@example.com", "default", "default", "never")
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.asc"), gpg("", "--armor", "--export-secret-keys", "mh-test@example.com"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return func(plaintext string) []byte {
+		return gpg(plaintext, "--trust-model", "always", "--encrypt", "--recipient", "mh-test@example.com")
+	}
+}
+
+func TestRenderConfigSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	encrypt := newTestPGPKey(t, dir)
+
+	password := base64.StdEncoding.EncodeToString(encrypt("s3cr3t-password"))
+	host := base64.StdEncoding.EncodeToString(encrypt("s3cr3t-host"))
+	if err := ioutil.WriteFile(filepath.Join(dir, "secrets.yaml.gpg"), encrypt("foo:\n  token: s3cr3t-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "main.yaml")
+	config := `
+encryption:
+  pgpKey: key.asc
+  files:
+  - secrets.yaml.gpg
+foo:
+  user: admin
+  password: ENC[pgp,` + password + `]
+  hosts: [public-host, "ENC[pgp,` + host + `]"]
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := RenderConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(rendered.Contents, "s3cr3t") {
+		t.Fatal("Decrypted secrets leaked into the rendered contents")
+	}
+
+	values := rendered.Values()
+	for key, expected := range map[string]string{"foo.password": "s3cr3t-password", "foo.token": "s3cr3t-token", "foo.user": "admin"} {
+		if value, err := values.PathValue(key); err != nil || value != expected {
+			t.Fatalf("%s is %v (%v), expected %s", key, value, err, expected)
+		}
+	}
+
+	// Encrypted list items are decrypted and explained with their list
+	if hosts, err := values.PathValue("foo.hosts"); err != nil || !reflect.DeepEqual(hosts, []interface{}{"public-host", "s3cr3t-host"}) {
+		t.Fatalf("foo.hosts is %v (%v)", hosts, err)
+	}
+	if _, ok := rendered.secretSources[".foo.hosts"]; !ok {
+		t.Fatalf("Unexpected secret sources: %v", rendered.secretSources)
+	}
+	if masked := rendered.Redactor.Redact("host s3cr3t-host"); masked != "host ***" {
+		t.Fatalf("Decrypted list item is not masked: %s", masked)
+	}
+
+	app := &App{}
+	overrides := []byte("password: s3cr3t-password\ntoken: \"s3cr3t-token\"\nuser: admin\n")
+	if printed := app.printable(rendered, overrides); printed != "password: '***'\ntoken: '***'\nuser: admin\n" {
+		t.Fatalf("Unexpected printed overrides: %s", printed)
+	}
+	app.PrintSecrets = true
+	if printed := app.printable(rendered, overrides); printed != string(overrides) {
+		t.Fatalf("Secrets masked although allowed: %s", printed)
+	}
+}
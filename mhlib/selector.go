@@ -0,0 +1,251 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Selector selects apps of a mh configuration.
+//
+// Patterns match the Name or Alias of apps exactly, as glob ("monitoring-*")
+// or as regular expression ("/^kafka/"). Patterns prefixed with "!" exclude
+// the apps they match. Without other patterns, all apps are selected.
+//
+// Labels are requirements on the labels of apps, all of which must be met:
+// "key=value" (or "key==value"), "key!=value", "key" (the label exists) and
+// "!key" (it does not). Besides the labels of AppConfigs, apps have the
+// labels "team" and "maintainer" from their effective MHConfig, the latter
+// matching any of their maintainers.
+type Selector struct {
+	Patterns []string
+	Labels   []string
+}
+
+// Empty returns true if the Selector selects all apps.
+func (s Selector) Empty() bool {
+	return len(s.Patterns) == 0 && len(s.Labels) == 0
+}
+
+// pattern is a parsed pattern of a Selector.
+type pattern struct {
+	text    string
+	negated bool
+	match   func(string) bool
+}
+
+// parsePattern parses a pattern of a Selector.
+func parsePattern(text string) (*pattern, error) {
+	p := &pattern{text: text}
+
+	expression := text
+	if strings.HasPrefix(expression, "!") {
+		p.negated = true
+		expression = expression[1:]
+	}
+	if expression == "" {
+		return nil, fmt.Errorf("Empty pattern %q", text)
+	}
+
+	if len(expression) > 1 && strings.HasPrefix(expression, "/") && strings.HasSuffix(expression, "/") {
+		re, err := regexp.Compile(expression[1 : len(expression)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression in pattern %q: %v", text, err)
+		}
+		p.match = re.MatchString
+		return p, nil
+	}
+
+	if _, err := path.Match(expression, ""); err != nil {
+		return nil, fmt.Errorf("Invalid glob in pattern %q: %v", text, err)
+	}
+	p.match = func(name string) bool {
+		matched, _ := path.Match(expression, name)
+		return matched
+	}
+
+	return p, nil
+}
+
+// matches returns true if the pattern, ignoring negation, matches an app.
+func (p *pattern) matches(appConfig *AppConfig) bool {
+	return p.match(appConfig.Name) || (appConfig.Alias != "" && p.match(appConfig.Alias))
+}
+
+// requirement is a parsed label requirement of a Selector.
+type requirement struct {
+	text     string
+	key      string
+	operator string
+	value    string
+}
+
+// parseRequirement parses a label requirement of a Selector.
+func parseRequirement(text string) (*requirement, error) {
+	r := &requirement{text: text}
+
+	switch {
+	case strings.Contains(text, "!="):
+		parts := strings.SplitN(text, "!=", 2)
+		r.key, r.operator, r.value = parts[0], "!=", parts[1]
+	case strings.Contains(text, "=="):
+		parts := strings.SplitN(text, "==", 2)
+		r.key, r.operator, r.value = parts[0], "=", parts[1]
+	case strings.Contains(text, "="):
+		parts := strings.SplitN(text, "=", 2)
+		r.key, r.operator, r.value = parts[0], "=", parts[1]
+	case strings.HasPrefix(text, "!"):
+		r.key, r.operator = text[1:], "!"
+	default:
+		r.key, r.operator = text, ""
+	}
+
+	r.key = strings.TrimSpace(r.key)
+	r.value = strings.TrimSpace(r.value)
+	if r.key == "" {
+		return nil, fmt.Errorf("Missing label key in selector %q", text)
+	}
+
+	return r, nil
+}
+
+// matches returns true if an app's labels meet the requirement. Labels may
+// have multiple values, a requirement on the value matches any of them.
+func (r *requirement) matches(labels map[string][]string) bool {
+	values, ok := labels[r.key]
+
+	switch r.operator {
+	case "":
+		return ok
+	case "!":
+		return !ok
+	}
+
+	found := false
+	for _, value := range values {
+		if value == r.value {
+			found = true
+			break
+		}
+	}
+	if r.operator == "!=" {
+		return !found
+	}
+
+	return found
+}
+
+// appLabels returns the labels of an app, including "team" and "maintainer"
+// from its effective MHConfig, unless they are set explicitly.
+func appLabels(appConfig *AppConfig, mhConfig MHConfig) map[string][]string {
+	labels := map[string][]string{}
+
+	team := appConfig.Team
+	if team == "" {
+		team = mhConfig.Team
+	}
+	if team != "" {
+		labels["team"] = []string{team}
+	}
+
+	maintainers := appConfig.Maintainers
+	if len(maintainers) == 0 {
+		maintainers = mhConfig.Maintainers
+	}
+	if len(maintainers) > 0 {
+		labels["maintainer"] = maintainers
+	}
+
+	for key, value := range appConfig.Labels {
+		labels[key] = []string{value}
+	}
+
+	return labels
+}
+
+// selectApps returns the indexes of the AppConfigs a Selector selects. It
+// fails if any pattern or label requirement matches no apps, so mistakes do
+// not go unnoticed.
+func (s Selector) selectApps(configs AppConfigs, mhConfig MHConfig) (map[int]bool, error) {
+	var patterns []*pattern
+	include := false
+	for _, text := range s.Patterns {
+		p, err := parsePattern(text)
+		if err != nil {
+			return nil, err
+		}
+		if !p.negated {
+			include = true
+		}
+		patterns = append(patterns, p)
+	}
+
+	var requirements []*requirement
+	for _, text := range s.Labels {
+		r, err := parseRequirement(text)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, r)
+	}
+
+	matched := map[string]bool{}
+	selected := map[int]bool{}
+	for i := range configs {
+		appConfig := &configs[i]
+
+		// Without including patterns, all apps are candidates
+		candidate := !include
+		excluded := false
+		for _, p := range patterns {
+			if p.matches(appConfig) {
+				matched[p.text] = true
+				if p.negated {
+					excluded = true
+				} else {
+					candidate = true
+				}
+			}
+		}
+
+		labels := appLabels(appConfig, mhConfig)
+		labelled := true
+		for _, r := range requirements {
+			if r.matches(labels) {
+				matched[r.text] = true
+			} else {
+				labelled = false
+			}
+		}
+
+		if candidate && !excluded && labelled {
+			selected[i] = true
+		}
+	}
+
+	for _, text := range append(append([]string{}, s.Patterns...), s.Labels...) {
+		if !matched[text] {
+			return nil, fmt.Errorf("Filter %q matches no apps", text)
+		}
+	}
+	if len(selected) == 0 && !s.Empty() {
+		return nil, fmt.Errorf("Filters %s match no apps together", strings.Join(append(append([]string{}, s.Patterns...), s.Labels...), ", "))
+	}
+
+	return selected, nil
+}
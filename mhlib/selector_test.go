@@ -0,0 +1,73 @@
+package mhlib
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var testSelectorConfigs = AppConfigs{
+	{Name: "monitoring-prometheus", Labels: map[string]string{"tier": "monitoring"}},
+	{Name: "monitoring-grafana", Labels: map[string]string{"tier": "monitoring"}},
+	{Name: "kafka", Alias: "kafka-main", Labels: map[string]string{"tier": "data"}},
+	{Name: "postgres", Labels: map[string]string{"tier": "data"}, MHConfig: MHConfig{Team: "dba"}},
+	{Name: "legacy-app", MHConfig: MHConfig{Maintainers: []string{"alice", "bob"}}},
+}
+
+func TestSelectorSelectApps(t *testing.T) {
+	tests := []struct {
+		selector Selector
+		expected []string
+	}{
+		{Selector{}, []string{"kafka", "legacy-app", "monitoring-grafana", "monitoring-prometheus", "postgres"}},
+		{Selector{Patterns: []string{"postgres"}}, []string{"postgres"}},
+		{Selector{Patterns: []string{"kafka-main"}}, []string{"kafka"}},
+		{Selector{Patterns: []string{"monitoring-*"}}, []string{"monitoring-grafana", "monitoring-prometheus"}},
+		{Selector{Patterns: []string{"/^kafka/"}}, []string{"kafka"}},
+		{Selector{Patterns: []string{"!legacy-app", "!monitoring-*"}}, []string{"kafka", "postgres"}},
+		{Selector{Patterns: []string{"monitoring-*", "!*grafana"}}, []string{"monitoring-prometheus"}},
+		{Selector{Labels: []string{"tier=data", "team!=sre"}}, []string{"postgres"}},
+		{Selector{Labels: []string{"tier==data"}}, []string{"kafka", "postgres"}},
+		{Selector{Labels: []string{"!tier"}}, []string{"legacy-app"}},
+		{Selector{Labels: []string{"maintainer=bob"}}, []string{"legacy-app"}},
+		{Selector{Patterns: []string{"/gres$/"}, Labels: []string{"team=dba"}}, []string{"postgres"}},
+	}
+
+	for _, test := range tests {
+		selected, err := test.selector.selectApps(testSelectorConfigs, DefaultMHConfig)
+		if err != nil {
+			t.Fatalf("%+v: %v", test.selector, err)
+		}
+
+		var names []string
+		for i := range selected {
+			names = append(names, testSelectorConfigs[i].Name)
+		}
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("%+v:\nActual: %v\nExpected: %v\n", test.selector, names, test.expected)
+		}
+	}
+}
+
+func TestSelectorMatchesNothing(t *testing.T) {
+	tests := []struct {
+		selector Selector
+		expected string
+	}{
+		{Selector{Patterns: []string{"postgres", "mysql"}}, `Filter "mysql" matches no apps`},
+		{Selector{Patterns: []string{"!mysql"}}, `Filter "!mysql" matches no apps`},
+		{Selector{Labels: []string{"tier=web"}}, `Filter "tier=web" matches no apps`},
+		{Selector{Patterns: []string{"kafka"}, Labels: []string{"team=dba"}}, "Filters kafka, team=dba match no apps together"},
+		{Selector{Patterns: []string{"/(/"}}, "Invalid regular expression in pattern \"/(/\": error parsing regexp: missing closing ): `(`"},
+		{Selector{Labels: []string{"=data"}}, `Missing label key in selector "=data"`},
+	}
+
+	for _, test := range tests {
+		_, err := test.selector.selectApps(testSelectorConfigs, DefaultMHConfig)
+		if err == nil || err.Error() != test.expected {
+			t.Fatalf("%+v:\nActual: %v\nExpected: %s\n", test.selector, err, test.expected)
+		}
+	}
+}
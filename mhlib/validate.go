@@ -0,0 +1,92 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+)
+
+// ValidateConfig validates a mh configuration against ConfigSchema. Unknown
+// keys are looked for in each file the configuration is composed of, so they
+// are reported with their file and line. Values are checked after
+// self-rendering, as templates may produce them.
+func ValidateConfig(config *RenderedConfig) (ValidationErrors, error) {
+	schema := ConfigSchema()
+
+	var errs ValidationErrors
+	for _, layer := range config.Layers {
+		for _, err := range validateSchema(schema, layer.document, nil) {
+			if !err.Unknown {
+				continue
+			}
+			if line := findLine(layer.Data, err.elements); line > 0 {
+				err.Location = fmt.Sprintf("%s:%d", layer.File, line)
+			} else {
+				err.Location = layer.File
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	rendered := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(config.Contents), &rendered); err != nil {
+		return nil, fmt.Errorf("Failed to parse configFile %v: %v", config.File, err)
+	}
+	for _, err := range validateSchema(schema, rendered, nil) {
+		if !err.Unknown {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs, nil
+}
+
+// ValidateValues validates rendered overrides against the values.schema.json
+// of the App's chart, if the chart is on disk and has one.
+func (a *App) ValidateValues(rendered *Rendered) error {
+	if !rendered.IsPath() {
+		return nil
+	}
+
+	schemaFile := filepath.Join(rendered.Chart, "values.schema.json")
+	data, err := ioutil.ReadFile(schemaFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to read chart values schema: %v", err)
+	}
+
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return fmt.Errorf("Failed to parse chart values schema %s: %v", schemaFile, err)
+	}
+
+	var values interface{}
+	if err := yaml.Unmarshal(rendered.Overrides, &values); err != nil {
+		return fmt.Errorf("Failed to load rendered overrides: %v", err)
+	}
+
+	if errs := validateSchema(schema, values, nil); len(errs) > 0 {
+		return fmt.Errorf("Overrides do not match %s: %v", schemaFile, errs)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package mhlib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"base.yaml": `
+mh:
+  noRecreatepods: true
+`,
+		"main.yaml": `
+include:
+- base.yaml
+mh:
+  parallelism: '[[ .workers ]]'
+apps:
+- name: foo
+  namepsace: default
+-
+  name: bar
+  labels:
+    tier: 1
+appSources:
+- name: apps
+  kind: path
+  source: /apps
+workers: "four"
+foo:
+  anything: goes
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, err := RenderConfig(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := ValidateConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, err := range errs {
+		actual = append(actual, strings.TrimPrefix(err.Error(), dir+"/"))
+	}
+	expected := []string{
+		"base.yaml:3: mh.noRecreatepods: unknown key noRecreatepods (did you mean noRecreatePods?)",
+		"main.yaml:8: apps[0].namepsace: unknown key namepsace",
+		"apps[1].labels.tier: expected string, got integer",
+		"mh.parallelism: expected integer, got string",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nActual: %q\nExpected: %q\n", actual, expected)
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"chart/values.schema.json": `{
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "image": {
+      "type": "object",
+      "properties": {"pullPolicy": {"enum": ["Always", "IfNotPresent"]}}
+    },
+    "replicas": {"type": "integer", "minimum": 1}
+  }
+}`,
+	})
+	defer os.RemoveAll(dir)
+
+	app := &App{}
+	chart := filepath.Join(dir, "chart")
+	tests := []struct {
+		overrides string
+		expected  string
+	}{
+		{"image: {pullPolicy: Always}\nreplicas: 2\n", ""},
+		{"replicas: 0\n", "missing required key image; replicas: must be at least 1"},
+		{"image: {pullPolicy: Never}\n", "image.pullPolicy: must be one of [Always IfNotPresent]"},
+	}
+	for _, test := range tests {
+		err := app.ValidateValues(&Rendered{Chart: chart, Overrides: []byte(test.overrides)})
+		if test.expected == "" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasSuffix(err.Error(), test.expected) {
+			t.Fatalf("\nActual: %v\nExpected: ...%s\n", err, test.expected)
+		}
+	}
+
+	// Charts from repositories are not validated
+	if err := app.ValidateValues(&Rendered{Chart: "stable/foo", Overrides: []byte("replicas: 0\n")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+
+	apps := schema["properties"].(map[string]interface{})["apps"].(map[string]interface{})
+	properties := apps["items"].(map[string]interface{})["properties"].(map[string]interface{})
+	for _, name := range []string{"name", "namespace", "dependsOn", "labels", "team", "helmVersion"} {
+		if _, ok := properties[name]; !ok {
+			t.Fatalf("App property %s missing from schema: %v", name, properties)
+		}
+	}
+	if _, ok := properties["SETValues"]; ok {
+		t.Fatal("Unconfigurable SETValues is part of the schema")
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mhlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// waitInterval is the time between checks of a release's workloads.
+var waitInterval = 2 * time.Second
+
+// workloadKinds are the kinds of objects waited for after applying an App.
+var workloadKinds = map[string]bool{
+	"DaemonSet":   true,
+	"Deployment":  true,
+	"Job":         true,
+	"StatefulSet": true,
+}
+
+// waitReady polls the workloads of the App's release until all of them are
+// ready. It fails once WaitTimeout passed, naming the workloads that are not
+// ready, or as soon as a Job failed.
+func (a *App) waitReady() error {
+	timeout, err := time.ParseDuration(a.WaitTimeout)
+	if err != nil {
+		return fmt.Errorf("Invalid waitTimeout %q: %v", a.WaitTimeout, err)
+	}
+
+	manifest, _, err := a.Backend.GetManifest(a.ID, a.Namespace)
+	if err != nil {
+		return fmt.Errorf("Failed to get manifest to wait for: %v", err)
+	}
+	workloads := manifestWorkloads(manifest, a.Namespace)
+	if len(workloads) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		unready, err := a.unreadyWorkloads(workloads)
+		if err != nil {
+			return err
+		}
+		if len(unready) == 0 {
+			a.log.Info("App is ready")
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out after %s waiting for %s", timeout, strings.Join(unready, "; "))
+		}
+		a.log.WithField("unready", unready).Info("Waiting for app to become ready")
+		time.Sleep(waitInterval)
+	}
+}
+
+// unreadyWorkloads returns a description of each workload that is not ready.
+func (a *App) unreadyWorkloads(workloads []KubeObject) ([]string, error) {
+	objects, err := a.Kube.Get(workloads)
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]workloadState{}
+	for _, object := range objects {
+		var state workloadState
+		if err := json.Unmarshal(object, &state); err != nil {
+			return nil, fmt.Errorf("Failed to parse workload: %v", err)
+		}
+		states[state.object(a.Namespace).String()] = state
+	}
+
+	var unready []string
+	for _, workload := range workloads {
+		state, ok := states[workload.String()]
+		if !ok {
+			unready = append(unready, workload.String()+": not found")
+			continue
+		}
+
+		reason, err := state.unready()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", workload, err)
+		}
+		if reason != "" {
+			unready = append(unready, workload.String()+": "+reason)
+		}
+	}
+
+	return unready, nil
+}
+
+// manifestWorkloads returns the workloads in a release manifest. Objects
+// without a namespace are in the given one.
+func manifestWorkloads(manifest, namespace string) []KubeObject {
+	var workloads []KubeObject
+
+	separator := regexp.MustCompile(`(?m)^---.*$`)
+	for _, document := range separator.Split(manifest, -1) {
+		var state workloadState
+		if err := yaml.Unmarshal([]byte(document), &state); err != nil || !workloadKinds[state.Kind] {
+			continue
+		}
+		workloads = append(workloads, state.object(namespace))
+	}
+
+	return workloads
+}
+
+// workloadState is the part of a workload's state needed to tell whether it
+// is ready.
+type workloadState struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas       *int32 `json:"replicas"`
+		Completions    *int32 `json:"completions"`
+		UpdateStrategy struct {
+			Type string `json:"type"`
+		} `json:"updateStrategy"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration     int64  `json:"observedGeneration"`
+		ReadyReplicas          int32  `json:"readyReplicas"`
+		UpdatedReplicas        int32  `json:"updatedReplicas"`
+		AvailableReplicas      int32  `json:"availableReplicas"`
+		CurrentRevision        string `json:"currentRevision"`
+		UpdateRevision         string `json:"updateRevision"`
+		DesiredNumberScheduled int32  `json:"desiredNumberScheduled"`
+		UpdatedNumberScheduled int32  `json:"updatedNumberScheduled"`
+		NumberAvailable        int32  `json:"numberAvailable"`
+		Succeeded              int32  `json:"succeeded"`
+		Conditions             []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// object returns the KubeObject of a workload, in the given namespace if it
+// has none.
+func (w *workloadState) object(namespace string) KubeObject {
+	if w.Metadata.Namespace != "" {
+		namespace = w.Metadata.Namespace
+	}
+
+	return KubeObject{Kind: w.Kind, Namespace: namespace, Name: w.Metadata.Name}
+}
+
+// unready returns why a workload is not ready yet, or an empty string if it is
+// ready. Failed Jobs are returned as errors, as waiting will not help.
+func (w *workloadState) unready() (string, error) {
+	// Jobs do not report the generation they observed
+	if w.Kind != "Job" && w.Status.ObservedGeneration < w.Metadata.Generation {
+		return "update not observed yet", nil
+	}
+
+	replicas := int32(1)
+	if w.Spec.Replicas != nil {
+		replicas = *w.Spec.Replicas
+	}
+
+	switch w.Kind {
+	case "Deployment":
+		if w.Status.UpdatedReplicas < replicas {
+			return fmt.Sprintf("%d/%d replicas updated", w.Status.UpdatedReplicas, replicas), nil
+		}
+		if w.Status.AvailableReplicas < replicas {
+			return fmt.Sprintf("%d/%d replicas available", w.Status.AvailableReplicas, replicas), nil
+		}
+	case "StatefulSet":
+		if w.Spec.UpdateStrategy.Type != "OnDelete" && w.Status.UpdateRevision != w.Status.CurrentRevision {
+			return fmt.Sprintf("%d/%d replicas updated", w.Status.UpdatedReplicas, replicas), nil
+		}
+		if w.Status.ReadyReplicas < replicas {
+			return fmt.Sprintf("%d/%d replicas ready", w.Status.ReadyReplicas, replicas), nil
+		}
+	case "DaemonSet":
+		desired := w.Status.DesiredNumberScheduled
+		if w.Status.UpdatedNumberScheduled < desired {
+			return fmt.Sprintf("%d/%d pods updated", w.Status.UpdatedNumberScheduled, desired), nil
+		}
+		if w.Status.NumberAvailable < desired {
+			return fmt.Sprintf("%d/%d pods available", w.Status.NumberAvailable, desired), nil
+		}
+	case "Job":
+		for _, condition := range w.Status.Conditions {
+			if condition.Type == "Failed" && condition.Status == "True" {
+				return "", fmt.Errorf("Job failed: %s", condition.Message)
+			}
+		}
+		completions := int32(1)
+		if w.Spec.Completions != nil {
+			completions = *w.Spec.Completions
+		}
+		if w.Status.Succeeded < completions {
+			return fmt.Sprintf("%d/%d completions succeeded", w.Status.Succeeded, completions), nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,124 @@
+package mhlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWorkloadsManifest = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: jobs
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`
+
+func deploymentState(available int) string {
+	return fmt.Sprintf(`
+kind: Deployment
+metadata: {name: web, namespace: apps, generation: 2}
+spec: {replicas: 2}
+status: {observedGeneration: 2, updatedReplicas: 2, availableReplicas: %d}
+`, available)
+}
+
+const succeededJob = `
+kind: Job
+metadata: {name: migrate, namespace: jobs, generation: 1}
+status: {succeeded: 1}
+`
+
+// newWaitingTestApps returns test apps waiting for readiness, with baz
+// deploying testWorkloadsManifest in the namespace apps.
+func newWaitingTestApps(t *testing.T, waitTimeout string) (Apps, *RenderedConfig, *FakeKubeClient) {
+	mhConfigFile := MHConfigFile{
+		Apps: AppConfigs{
+			{Name: "bar", Alias: "baz", Namespace: "apps"},
+			{Name: "foo", DependsOn: []string{"baz"}},
+		},
+		AppSources: testMHConfigFile.AppSources,
+	}
+	apps, config, backend := newTestApps(t, mhConfigFile)
+	backend.Manifests["baz"] = testWorkloadsManifest
+
+	kube := NewFakeKubeClient()
+	for i := range apps {
+		apps[i].Wait = true
+		apps[i].WaitTimeout = waitTimeout
+		apps[i].Kube = kube
+	}
+
+	return apps, config, kube
+}
+
+func TestAppsApplyWait(t *testing.T) {
+	defer func(interval time.Duration) { waitInterval = interval }(waitInterval)
+	waitInterval = time.Millisecond
+
+	apps, config, kube := newWaitingTestApps(t, "1m")
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	kube.Objects["Deployment apps/web"] = []string{deploymentState(0), deploymentState(1), deploymentState(2)}
+	kube.Objects["Job jobs/migrate"] = []string{succeededJob}
+
+	if err := apps.Apply(config, RunOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if reads := kube.Reads["Deployment apps/web"]; reads != 3 {
+		t.Fatalf("Deployment was read %d times", reads)
+	}
+}
+
+func TestAppsApplyWaitTimeout(t *testing.T) {
+	defer func(interval time.Duration) { waitInterval = interval }(waitInterval)
+	waitInterval = time.Millisecond
+
+	apps, config, kube := newWaitingTestApps(t, "10ms")
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	kube.Objects["Deployment apps/web"] = []string{deploymentState(1)}
+
+	results := apps.Apply(config, RunOptions{KeepGoing: true})
+	if results[0].Status() != "failed" || results[1].Status() != "skipped" {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+	message := results[0].Error.Error()
+	for _, expected := range []string{"Deployment apps/web: 1/2 replicas available", "Job jobs/migrate: not found"} {
+		if !strings.Contains(message, expected) {
+			t.Fatalf("Error %q does not contain %q", message, expected)
+		}
+	}
+}
+
+func TestAppsApplyWaitFailedJob(t *testing.T) {
+	apps, config, kube := newWaitingTestApps(t, "1h")
+	defer os.RemoveAll(filepath.Dir(config.File))
+
+	kube.Objects["Deployment apps/web"] = []string{deploymentState(2)}
+	kube.Objects["Job jobs/migrate"] = []string{`
+kind: Job
+metadata: {name: migrate, namespace: jobs}
+status:
+  conditions:
+  - {type: Failed, status: "True", message: Job has reached the specified backoff limit}
+`}
+
+	err := apps.Apply(config, RunOptions{}).Err()
+	if err == nil || !strings.Contains(err.Error(), "Job jobs/migrate: Job failed: Job has reached the specified backoff limit") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}